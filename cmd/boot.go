@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/config"
+	"github.com/SandwichLabs/duck-tape/script"
+	"github.com/SandwichLabs/duck-tape/workspace"
+)
+
+// BootScript is SQL run on every connection after its connections are attached, such as
+// SET options, macros and views. It runs again for every connection of every dt process, so
+// anything it creates must be TEMP, OR REPLACE or IF NOT EXISTS, see checkRerunnable.
+type BootScript struct {
+	// Name identifies the script in errors.
+	Name string
+	SQL  string
+}
+
+// run executes each statement in the script, reporting the first failure with its line.
+func (b BootScript) run(exec func(string) error) error {
+	for _, statement := range script.Split(b.SQL) {
+		if err := checkRerunnable(statement.SQL); err != nil {
+			return fmt.Errorf("boot SQL %s (line %d): %w", b.Name, statement.Line, err)
+		}
+		if err := exec(statement.SQL); err != nil {
+			return fmt.Errorf("boot SQL %s (line %d): %w", b.Name, statement.Line, err)
+		}
+	}
+	return nil
+}
+
+// checkRerunnable rejects a CREATE that fails when it runs a second time. A plain CREATE
+// MACRO or CREATE VIEW in the workspace database succeeds on the first start and fails on
+// every one after it.
+func checkRerunnable(sql string) error {
+	words, _ := script.Words(sql)
+	if len(words) == 0 || !strings.EqualFold(words[0], "create") {
+		return nil
+	}
+	// The keywords that make it rerunnable come before the name, e.g. CREATE UNIQUE INDEX IF NOT EXISTS
+	head := strings.ToLower(" " + strings.Join(words[1:min(len(words), 7)], " ") + " ")
+	for _, keywords := range []string{" temp ", " temporary ", " or replace ", " if not exists "} {
+		if strings.Contains(head, keywords) {
+			return nil
+		}
+	}
+	return fmt.Errorf("CREATE runs on every connection, so it must be TEMP, OR REPLACE or IF NOT EXISTS")
+}
+
+// runBootScripts runs every script, so that each broken file is reported rather than
+// only the first.
+func runBootScripts(scripts []BootScript, exec func(string) error) error {
+	var errs []error
+	for _, bootScript := range scripts {
+		if err := bootScript.run(exec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// workspaceMacrosDir is the folder of .sql files run on boot: macros next to the project
+// file for a project workspace, otherwise macros in the workspace folder.
+func workspaceMacrosDir(ws string) string {
+	if project := workspace.ActiveProject(); project != nil && project.Workspace == ws {
+		return filepath.Join(project.Dir(), "macros")
+	}
+	return filepath.Join(config.WorkspacePath(ws), "macros")
+}
+
+// workspaceBootScripts returns the workspace's boot setting followed by the files in its
+// macros folder, in name order.
+func workspaceBootScripts(ws string) ([]BootScript, error) {
+	var scripts []BootScript
+	for i, sql := range workspace.WorkspaceBootSQL(ws) {
+		scripts = append(scripts, BootScript{Name: fmt.Sprintf("%s.boot[%d]", ws, i), SQL: sql})
+	}
+
+	dir := workspaceMacrosDir(ws)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return scripts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading macros: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".sql") {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		sql, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading macros: %w", err)
+		}
+		scripts = append(scripts, BootScript{Name: path, SQL: string(sql)})
+	}
+	return scripts, nil
+}
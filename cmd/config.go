@@ -0,0 +1,211 @@
+/*
+Copyright © 2024 Zac Orndorff <zac@orndorff.dev>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/output"
+	"github.com/SandwichLabs/duck-tape/redact"
+	"github.com/SandwichLabs/duck-tape/workspace"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect dt's configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		cobra.CheckErr(err)
+	},
+}
+
+var configExplainCmd = &cobra.Command{
+	Use:   "explain [key prefix]",
+	Short: "Show each setting and where its value came from",
+	Long: `Lists every setting with its value and its source: a command line flag, an
+	environment variable, the project file (.dt.yaml or dt.yaml in the working directory
+	or a parent), the user config in ~/.dt or dt's default. Credentials are redacted.
+	dt config explain
+	dt config explain dev.connections`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		prefix := ""
+		if len(args) > 0 {
+			prefix = strings.ToLower(args[0])
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		formatter, err := output.New(format, cmd.OutOrStdout(), output.Options{Header: true})
+		cobra.CheckErr(err)
+
+		err = formatter.WriteHeader([]output.Column{
+			{Name: "key", Type: "VARCHAR"},
+			{Name: "value", Type: "VARCHAR"},
+			{Name: "source", Type: "VARCHAR"},
+		})
+		cobra.CheckErr(err)
+
+		for _, setting := range explainConfig() {
+			if !strings.HasPrefix(setting.Key, prefix) {
+				continue
+			}
+			err = formatter.WriteRow([]interface{}{setting.Key, setting.Value, setting.Source})
+			cobra.CheckErr(err)
+		}
+		err = formatter.Flush()
+		cobra.CheckErr(err)
+	},
+}
+
+var configTrustCmd = &cobra.Command{
+	Use:   "trust [project file]",
+	Short: "Trust a project's .dt.yaml so dt uses it",
+	Long: `A project file can run shell commands through ${cmd:...} references, read files through
+	${file:...}, and run SQL from its boot setting and macros folder. dt ignores a project file
+	until it is trusted, and again whenever it changes outside dt, so review it first.
+	dt config trust
+	dt config trust ../other/.dt.yaml
+	dt config untrust`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		project, err := projectArg(args)
+		cobra.CheckErr(err)
+		err = projectTrust().Trust(project)
+		cobra.CheckErr(err)
+		slog.Info("Trusted project file", "project", project.Path, "workspace", project.Workspace)
+	},
+}
+
+var configUntrustCmd = &cobra.Command{
+	Use:   "untrust [project file]",
+	Short: "Stop using a project's .dt.yaml",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		project, err := projectArg(args)
+		cobra.CheckErr(err)
+		err = projectTrust().Untrust(project.Path)
+		cobra.CheckErr(err)
+		slog.Info("Untrusted project file", "project", project.Path)
+	},
+}
+
+// projectArg loads the project file given as an argument, or else the one found from the
+// working directory.
+func projectArg(args []string) (*workspace.Project, error) {
+	if len(args) > 0 {
+		return workspace.LoadProject(args[0])
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	path, ok := workspace.FindProject(cwd)
+	if !ok {
+		return nil, fmt.Errorf("no %s in %s or its parents", strings.Join(workspace.ProjectFileNames, " or "), cwd)
+	}
+	return workspace.LoadProject(path)
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configExplainCmd)
+	configCmd.AddCommand(configTrustCmd)
+	configCmd.AddCommand(configUntrustCmd)
+
+	configExplainCmd.Flags().StringP("format", "F", "table", fmt.Sprintf("Output format (%s)", strings.Join(output.Names(), ", ")))
+}
+
+// configSetting is a setting with the value dt uses and where it came from.
+type configSetting struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// explainConfig returns every setting, sorted by key, with the layer it was taken from.
+func explainConfig() []configSetting {
+	settings := map[string]interface{}{}
+	flattenSettings("", viper.AllSettings(), settings)
+	for key := range globalSettings {
+		settings[key] = globalSetting(key)
+	}
+
+	userConfig := map[string]interface{}{}
+	user := viper.New()
+	user.SetConfigFile(viper.ConfigFileUsed())
+	if err := user.ReadInConfig(); err == nil {
+		flattenSettings("", user.AllSettings(), userConfig)
+	}
+
+	project := workspace.ActiveProject()
+	projectConfig := map[string]interface{}{}
+	if project != nil {
+		flattenSettings(project.Workspace+".", project.Settings, projectConfig)
+		flattenSettings("", project.Globals, projectConfig)
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	explained := make([]configSetting, 0, len(keys))
+	for _, key := range keys {
+		var source string
+		flag := rootCmd.PersistentFlags().Lookup(strings.ReplaceAll(key, "_", "-"))
+		_, inProject := projectConfig[key]
+		_, inUserConfig := userConfig[key]
+
+		switch {
+		case flag != nil && flag.Changed:
+			source = "flag --" + flag.Name
+		case !strings.Contains(key, ".") && os.Getenv(strings.ToUpper(key)) != "":
+			source = "env " + strings.ToUpper(key)
+		case key == "workspace" && project != nil:
+			source = "project " + project.Path
+		case key == "workspace" && viper.GetString(workspace.DefaultWorkspaceKey) != "":
+			source = fmt.Sprintf("user config %s (%s)", viper.ConfigFileUsed(), workspace.DefaultWorkspaceKey)
+		case inProject:
+			source = "project " + project.Path
+		case inUserConfig:
+			source = "user config " + viper.ConfigFileUsed()
+		default:
+			source = "default"
+		}
+
+		explained = append(explained, configSetting{Key: key, Value: redact.String(settingString(settings[key])), Source: source})
+	}
+	return explained
+}
+
+// flattenSettings adds the leaves of a nested settings map to out under dotted keys.
+func flattenSettings(prefix string, settings map[string]interface{}, out map[string]interface{}) {
+	for key, value := range settings {
+		key = prefix + strings.ToLower(key)
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenSettings(key+".", nested, out)
+			continue
+		}
+		out[key] = value
+	}
+}
+
+func settingString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
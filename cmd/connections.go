@@ -4,21 +4,359 @@ Copyright © 2024 Zac Orndorff <zac@orndorff.dev>
 package cmd
 
 import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+
 	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/SandwichLabs/duck-tape/output"
+	"github.com/SandwichLabs/duck-tape/redact"
 	"github.com/SandwichLabs/duck-tape/workspace"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"log/slog"
 )
 
 var setConnectionCmd = &cobra.Command{
 	Use:   "connection",
 	Short: "Set a connection",
+	Long: `Creates or replaces a connection in the workspace.
+	Without flags an interactive form is shown. Pass --name, --type and --conn-string to skip it:
+	dt set connection --name pg --type postgres --conn-string "host=localhost dbname=app" --write`,
+	Run: func(cmd *cobra.Command, args []string) {
+		workspaceStr := viper.GetString("workspace")
+
+		var conn connection.ConnectionConfig
+		if cmd.Flags().Changed("name") || cmd.Flags().Changed("conn-string") {
+			conn = connectionFromFlags(cmd)
+		} else {
+			var err error
+			conn, err = connection.ConnectionConfigForm(connection.ConnectionConfig{})
+			cobra.CheckErr(err)
+		}
+
+		err := conn.Validate()
+		cobra.CheckErr(err)
+
+		slog.Info("saving connection", "connection", conn.Redacted())
+		_, err = workspace.SetWorkspaceConnection(workspaceStr, conn, true)
+		cobra.CheckErr(err)
+	},
+}
+
+var connectionCmd = &cobra.Command{
+	Use:     "connection",
+	Aliases: []string{"connections", "conn"},
+	Short:   "Manage workspace connections",
+	Long: `List, inspect, remove and rename the connections in a workspace.
+	Create connections with: dt set connection`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		cobra.CheckErr(err)
+	},
+}
+
+var connectionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the connections in the workspace",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		workspaceStr := viper.GetString("workspace")
+
+		names, err := workspace.ListWorkspaceConnections(workspaceStr)
+		cobra.CheckErr(err)
+
+		connections := make([]connection.ConnectionConfig, 0, len(names))
+		for _, name := range names {
+			conn, err := workspace.WorkspaceConnection(workspaceStr, name)
+			cobra.CheckErr(err)
+			connections = append(connections, conn.Redacted())
+		}
+
+		err = writeConnections(cmd, connections, false)
+		cobra.CheckErr(err)
+	},
+}
+
+var connectionShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Show a connection with its credentials redacted",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conn, err := workspace.WorkspaceConnection(viper.GetString("workspace"), args[0])
+		cobra.CheckErr(err)
+
+		err = writeConnections(cmd, []connection.ConnectionConfig{conn.Redacted()}, true)
+		cobra.CheckErr(err)
+	},
+}
+
+var connectionRmCmd = &cobra.Command{
+	Use:     "rm <name>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a connection from the workspace",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, err := workspace.DeleteWorkspaceConnection(viper.GetString("workspace"), args[0], true)
+		cobra.CheckErr(err)
+		slog.Info("Removed connection", "name", args[0])
+	},
+}
+
+var connectionRenameCmd = &cobra.Command{
+	Use:   "rename <old name> <new name>",
+	Short: "Rename a connection",
+	Long: `Renames a connection. The name is also the catalog alias it is attached as,
+	so queries and saved queries that reference the old name need to be updated.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := connection.ValidateName(args[1])
+		cobra.CheckErr(err)
+
+		_, err = workspace.RenameWorkspaceConnection(viper.GetString("workspace"), args[0], args[1], true)
+		cobra.CheckErr(err)
+		slog.Info("Renamed connection", "from", args[0], "to", args[1])
+	},
+}
+
+var connectionDefaultCmd = &cobra.Command{
+	Use:   "default [name...]",
+	Short: "List or add the workspace's default connections",
+	Long: `Default connections are available to every query in the workspace without -c.
+	Each is only attached when the query references it: by catalog name, or for storage
+	connections by a path under the secret's scope.
+	dt connection default pg lake
+	dt connection default --rm lake
+	dt connection default`,
+	Run: func(cmd *cobra.Command, args []string) {
+		workspaceStr := viper.GetString("workspace")
+		defaults := workspace.WorkspaceDefaultConnections(workspaceStr)
+
+		if len(args) == 0 {
+			for _, name := range defaults {
+				fmt.Fprintln(cmd.OutOrStdout(), name)
+			}
+			return
+		}
+
+		remove, _ := cmd.Flags().GetBool("rm")
+		for _, name := range args {
+			if remove {
+				defaults = slices.DeleteFunc(defaults, func(d string) bool { return d == name })
+				continue
+			}
+			_, err := workspace.WorkspaceConnection(workspaceStr, name)
+			cobra.CheckErr(err)
+			if !slices.Contains(defaults, name) {
+				defaults = append(defaults, name)
+			}
+		}
+
+		_, err := workspace.SetWorkspaceDefaultConnections(workspaceStr, defaults, true)
+		cobra.CheckErr(err)
+		slog.Info("Updated default connections", "connections", defaults)
+	},
+}
+
+var connectionEditCmd = &cobra.Command{
+	Use:   "edit <name>",
+	Short: "Edit a connection with the interactive form",
+	Long: `Opens the connection form pre-filled with a saved connection.
+	Changing the name renames the connection.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		workspaceStr := viper.GetString("workspace")
-		connection := connection.ConnectionConfigForm()
-		slog.Info("saving connection", "connection", connection)
-		_, err := workspace.SetWorkspaceConnection(workspaceStr, connection, true)
+
+		existing, err := workspace.WorkspaceConnection(workspaceStr, args[0])
+		cobra.CheckErr(err)
+
+		conn, err := connection.ConnectionConfigForm(existing)
+		cobra.CheckErr(err)
+
+		err = conn.Validate()
+		cobra.CheckErr(err)
+
+		if conn.Name != existing.Name {
+			_, err = workspace.DeleteWorkspaceConnection(workspaceStr, existing.Name, false)
+			cobra.CheckErr(err)
+		}
+
+		slog.Info("saving connection", "connection", conn.Redacted())
+		_, err = workspace.SetWorkspaceConnection(workspaceStr, conn, true)
 		cobra.CheckErr(err)
 	},
 }
+
+var connectionTestCmd = &cobra.Command{
+	Use:   "test [name...]",
+	Short: "Attach connections in isolation and report what works",
+	Long: `Attaches each connection on its own scratch in-memory database, so one broken
+connection doesn't hide the others. For every connection it reports whether the
+required extensions installed and loaded, whether ATTACH succeeded and how long it
+took, how many schemas are visible and whether the catalog is writable.
+
+Tests every connection in the workspace when no names are given, and exits non-zero
+when any connection fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		workspaceStr := viper.GetString("workspace")
+
+		names := args
+		if len(names) == 0 {
+			var err error
+			names, err = workspace.ListWorkspaceConnections(workspaceStr)
+			cobra.CheckErr(err)
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		formatter, err := output.New(format, cmd.OutOrStdout(), output.Options{Header: true})
+		cobra.CheckErr(err)
+
+		err = formatter.WriteHeader([]output.Column{
+			{Name: "name", Type: "VARCHAR"},
+			{Name: "type", Type: "VARCHAR"},
+			{Name: "extensions", Type: "VARCHAR"},
+			{Name: "attached", Type: "BOOLEAN"},
+			{Name: "latency_ms", Type: "BIGINT"},
+			{Name: "schemas", Type: "INTEGER"},
+			{Name: "writable", Type: "BOOLEAN"},
+			{Name: "failed_step", Type: "VARCHAR"},
+			{Name: "error", Type: "VARCHAR"},
+		})
+		cobra.CheckErr(err)
+
+		failed := 0
+		for _, name := range names {
+			d := testConnection(cmd, workspaceStr, name)
+			if !d.OK() {
+				failed++
+			}
+
+			var errStr interface{}
+			var step interface{}
+			if d.Err != nil {
+				errStr, step = redact.String(d.Err.Error()), d.Step
+			}
+			err = formatter.WriteRow([]interface{}{
+				d.Name, d.Type, extensionSummary(d.Extensions), d.Attached,
+				d.Latency.Milliseconds(), d.Schemas, d.Writable, step, errStr,
+			})
+			cobra.CheckErr(err)
+		}
+		cobra.CheckErr(formatter.Flush())
+
+		if failed > 0 {
+			cobra.CheckErr(fmt.Errorf("%d of %d connections failed", failed, len(names)))
+		}
+	},
+}
+
+// testConnection diagnoses a single workspace connection on a fresh in-memory database.
+func testConnection(cmd *cobra.Command, workspaceStr string, name string) connection.Diagnostics {
+	conn, err := workspace.WorkspaceConnection(workspaceStr, name)
+	if err != nil {
+		return connection.Diagnostics{Name: name, Step: "config", Err: err}
+	}
+	if err := conn.Validate(); err != nil {
+		return connection.Diagnostics{Name: name, Type: conn.Type, Step: "config", Err: err}
+	}
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return connection.Diagnostics{Name: name, Type: conn.Type, Step: "attach", Err: err}
+	}
+	defer db.Close()
+
+	return connection.Diagnose(cmd.Context(), db, conn)
+}
+
+// extensionSummary renders extension statuses as "name: status" pairs in a stable order.
+func extensionSummary(extensions map[string]string) string {
+	names := make([]string, 0, len(extensions))
+	for name := range extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, extensions[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func init() {
+	// WORKSPACE connections attach another workspace's database wherever its dbLocation points
+	connection.WorkspaceDatabase = func(name string) string {
+		return workspaceDatabasePath(name, viper.GetString(fmt.Sprintf("%s.dbLocation", name)))
+	}
+
+	setConnectionCmd.Flags().String("name", "", "Connection name, also used as the catalog alias in queries")
+	setConnectionCmd.Flags().String("type", "", fmt.Sprintf("Connection type (%s)", strings.Join(connection.TypeNames(), ", ")))
+	setConnectionCmd.Flags().String("conn-string", "", "Connection string passed to ATTACH")
+	setConnectionCmd.Flags().Bool("write", false, "Attach the connection read-write instead of read-only")
+
+	rootCmd.AddCommand(connectionCmd)
+	connectionCmd.AddCommand(connectionListCmd)
+	connectionCmd.AddCommand(connectionShowCmd)
+	connectionCmd.AddCommand(connectionRmCmd)
+	connectionCmd.AddCommand(connectionRenameCmd)
+	connectionCmd.AddCommand(connectionTestCmd)
+	connectionCmd.AddCommand(connectionEditCmd)
+	connectionCmd.AddCommand(connectionDefaultCmd)
+
+	connectionDefaultCmd.Flags().Bool("rm", false, "Remove the connections from the defaults")
+
+	formatHelp := fmt.Sprintf("Output format (%s)", strings.Join(output.Names(), ", "))
+	connectionListCmd.Flags().StringP("format", "F", "table", formatHelp)
+	connectionShowCmd.Flags().StringP("format", "F", "ndjson", formatHelp)
+	connectionTestCmd.Flags().StringP("format", "F", "table", formatHelp)
+}
+
+func connectionFromFlags(cmd *cobra.Command) connection.ConnectionConfig {
+	name, _ := cmd.Flags().GetString("name")
+	connType, _ := cmd.Flags().GetString("type")
+	connString, _ := cmd.Flags().GetString("conn-string")
+	enableWrite, _ := cmd.Flags().GetBool("write")
+
+	return connection.ConnectionConfig{
+		Name:        name,
+		Type:        strings.ToUpper(connType),
+		ConnString:  connString,
+		EnableWrite: enableWrite,
+	}
+}
+
+// writeConnections prints connections through the --format formatter.
+// The connection string is only included when withConnString is set.
+func writeConnections(cmd *cobra.Command, connections []connection.ConnectionConfig, withConnString bool) error {
+	format, _ := cmd.Flags().GetString("format")
+	formatter, err := output.New(format, cmd.OutOrStdout(), output.Options{Header: true})
+	if err != nil {
+		return err
+	}
+
+	columns := []output.Column{
+		{Name: "name", Type: "VARCHAR"},
+		{Name: "type", Type: "VARCHAR"},
+		{Name: "enable_write", Type: "BOOLEAN"},
+	}
+	if withConnString {
+		columns = append(columns, output.Column{Name: "conn_string", Type: "VARCHAR"})
+	}
+	if err := formatter.WriteHeader(columns); err != nil {
+		return err
+	}
+
+	for _, conn := range connections {
+		row := []interface{}{conn.Name, conn.Type, conn.EnableWrite}
+		if withConnString {
+			row = append(row, conn.ConnString)
+		}
+		if err := formatter.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return formatter.Flush()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"github.com/SandwichLabs/duck-tape/output"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/exp/slog"
@@ -31,21 +32,33 @@ Example:
 
 		cobra.CheckErr(err)
 
-		dbPath := viper.GetString(fmt.Sprintf("%s.dbLocation", workspace))
+		dbPath, err := commandDatabasePath(cmd, workspace)
+		checkErr(err)
+		if lockErr := daemonLock(workspace, dbPath); lockErr != nil {
+			checkErr(lockErr)
+		}
 		slog.Debug("Database path:", "dbPath", dbPath)
 
 		slog.Debug("Using database", "path", dbPath)
 
+		engine, err := workspaceEngine(cmd, workspace)
+		checkErr(err)
+
 		client := NewDatabaseClient(
-			WithNumThreads(4), // Or get from config/flags
+			WithEngine(engine),
 			WithWorkspace(workspace),
 			WithDatabasePath(dbPath),
 			WithConnectionsByName(connectionNames), // Reuse connection logic
+			WithConnectionErrorPolicy(connectionErrorPolicy()),
+			WithWorkspaceBoot(),
+			WithLockTimeout(lockTimeout()),
+			// Context only reads the database
+			WithReadOnlyFallback(func() bool { return true }),
 			InitDatabaseClient(),
 		)
 
 		db, err := OpenConnection(*client)
-		cobra.CheckErr(err)
+		checkErr(err)
 		defer db.Close()
 		slog.Debug("Database connection established", "workspace", workspace, "db", dbPath)
 
@@ -94,6 +107,7 @@ func init() {
 	contextCmd.Flags().StringArrayP("connections", "c", []string{}, "One or more connection configurations to attach (same as query)")
 	contextCmd.Flags().StringArrayP("fragments", "f", []string{}, "Include additional context from a file (can be used multiple times)")
 	contextCmd.Flags().Bool("summary", false, "Include the results of running SUMMARIZE on the tables in the database")
+	addDatabaseFlags(contextCmd)
 
 }
 
@@ -202,53 +216,14 @@ func getTableNames(db *sql.DB) ([]string, error) {
 
 // Helper to format sql.Rows to a simple markdown table
 func formatRowsToMarkdown(rows *sql.Rows) (string, error) {
-	cols, err := rows.Columns()
-	if err != nil {
-		return "", fmt.Errorf("failed to get columns: %w", err)
-	}
-
 	var md strings.Builder
-	md.WriteString("| " + strings.Join(cols, " | ") + " |\n")
-	md.WriteString("|" + strings.Repeat("---|", len(cols)) + "\n")
-
-	// Use interface{} to handle different types, including duckdb.Decimal
-	pointers := make([]interface{}, len(cols))
-	container := make([]interface{}, len(cols)) // Use interface{} for flexibility
-	for i := range pointers {
-		pointers[i] = &container[i]
-	}
-
-	for rows.Next() {
-		err = rows.Scan(pointers...)
-		if err != nil {
-			return "", fmt.Errorf("failed to scan row: %w", err)
-		}
-		md.WriteString("| ")
-		for i := range cols {
-			// Handle potential NULLs and unsupported types
-			val := container[i]
-			if val == nil {
-				md.WriteString("NULL")
-			} else {
-				switch v := val.(type) {
-				case []byte:
-					md.WriteString(string(v)) // Handle RawBytes
-				case string:
-					md.WriteString(v)
-				case float64, int64, int, float32:
-					md.WriteString(fmt.Sprintf("%v", v))
-				default:
-					md.WriteString(fmt.Sprintf("%v", v)) // Fallback for other types
-				}
-			}
-			md.WriteString(" | ")
-		}
-		md.WriteString("\n")
+	formatter, err := output.New("markdown", &md, output.Options{})
+	if err != nil {
+		return "", err
 	}
-	if err = rows.Err(); err != nil {
-		return "", fmt.Errorf("error iterating rows: %w", err)
+	if err := output.WriteRows(formatter, rows); err != nil {
+		return "", err
 	}
-
 	return md.String(), nil
 }
 
@@ -0,0 +1,445 @@
+/*
+Copyright © 2024 Zac Orndorff <zac@orndorff.dev>
+*/
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SandwichLabs/duck-tape/config"
+	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/SandwichLabs/duck-tape/daemon"
+	"github.com/SandwichLabs/duck-tape/param"
+	"github.com/SandwichLabs/duck-tape/script"
+	"github.com/SandwichLabs/duck-tape/workspace"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// daemonStartTimeout bounds how long dt daemon start waits for connections to attach.
+const daemonStartTimeout = time.Minute
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keep the workspace database and its connections open in the background",
+	Long: `Starts a background process that holds the workspace database open with its
+	connections attached. dt query and dt run forward their SQL to it over a Unix socket
+	in the workspace folder, skipping extension loading and ATTACH on every call.
+	dt daemon start -c pg
+	dt q "select * from pg.users"
+	dt daemon status
+	dt daemon stop
+
+	Relative paths read by a query are resolved from the caller's directory. Files written
+	by COPY in the SQL itself are relative to the workspace folder, so pass absolute paths
+	(-o is made absolute for you). Restart the daemon after editing a connection.
+
+	The daemon keeps the workspace database locked while it runs. Queries with piped stdin,
+	--each, --no-daemon or engine flags can't be sent to it, and neither can dt context,
+	so they fail straight away with the daemon's PID rather than waiting for the lock. Run
+	them with --memory or --db, or stop the daemon first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		cobra.CheckErr(err)
+	},
+}
+
+var daemonStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the daemon for the workspace",
+	Long: `Starts the daemon in the background, attaching the connections passed with -c and
+	the workspace's default connections up front. Connections that queries pass with -c
+	are attached on first use and then stay attached.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		foreground, _ := cmd.Flags().GetBool("foreground")
+		if foreground {
+			err := serveDaemon(cmd)
+			checkErr(err)
+			return
+		}
+		err := startDaemon(cmd)
+		checkErr(err)
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the daemon's status, exiting with 1 when it isn't running",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ws := viper.GetString("workspace")
+		status, err := daemonClient(ws).Status()
+		if errors.Is(err, daemon.ErrNotRunning) {
+			err = fmt.Errorf("no daemon running for workspace %q", ws)
+		}
+		cobra.CheckErr(err)
+
+		err = writeDaemonStatus(cmd.OutOrStdout(), status)
+		cobra.CheckErr(err)
+	},
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the daemon once the queries it is running finish",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ws := viper.GetString("workspace")
+		client := daemonClient(ws)
+		err := client.Stop()
+		if errors.Is(err, daemon.ErrNotRunning) {
+			err = fmt.Errorf("no daemon running for workspace %q", ws)
+		}
+		cobra.CheckErr(err)
+
+		for daemon.Running(client.Path) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		slog.Info("Stopped daemon", "workspace", ws)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.AddCommand(daemonStartCmd)
+	daemonCmd.AddCommand(daemonStatusCmd)
+	daemonCmd.AddCommand(daemonStopCmd)
+
+	daemonStartCmd.Flags().StringArrayP("connections", "c", []string{}, "Connections to attach when the daemon starts")
+	daemonStartCmd.Flags().Duration("idle-timeout", 10*time.Minute, "Stop the daemon after this long without a query (0 keeps it running)")
+	daemonStartCmd.Flags().Bool("foreground", false, "Run the daemon in this process instead of in the background")
+}
+
+func daemonClient(ws string) daemon.Client {
+	return daemon.Client{Path: daemon.SocketPath(config.WorkspacePath(ws))}
+}
+
+// startDaemon runs dt daemon start --foreground as a detached process logging to
+// daemon.log in the workspace folder, and waits for it to accept queries.
+func startDaemon(cmd *cobra.Command) error {
+	ws := viper.GetString("workspace")
+	client := daemonClient(ws)
+	if daemon.Running(client.Path) {
+		return fmt.Errorf("a daemon is already running for workspace %q", ws)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{"daemon", "start", "--foreground", "--workspace", ws, "--on-connection-error", connectionErrorPolicy()}
+	if cfgFile != "" {
+		args = append(args, "--config", cfgFile)
+	}
+	idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+	args = append(args, "--idle-timeout", idleTimeout.String())
+	connectionNames, _ := cmd.Flags().GetStringArray("connections")
+	for _, name := range connectionNames {
+		args = append(args, "--connections", name)
+	}
+	args = append(args, engineArgs(cmd)...)
+
+	workspacePath := config.WorkspacePath(ws)
+	logPath := filepath.Join(workspacePath, "daemon.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	child := exec.Command(executable, args...)
+	child.Dir = workspacePath
+	child.Stdout = logFile
+	child.Stderr = logFile
+	child.SysProcAttr = detachedProcAttr()
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("starting daemon: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- child.Wait() }()
+
+	deadline := time.Now().Add(daemonStartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("daemon exited during startup (%v), see %s", err, logPath)
+		case <-time.After(50 * time.Millisecond):
+		}
+		// Blocks until the daemon has attached its connections and starts serving
+		if status, err := client.Status(); err == nil {
+			return writeDaemonStatus(cmd.OutOrStdout(), status)
+		}
+	}
+	return fmt.Errorf("daemon didn't start within %s, see %s", daemonStartTimeout, logPath)
+}
+
+// serveDaemon opens the workspace database, attaches the startup connections and answers
+// queries on the workspace socket until stopped.
+func serveDaemon(cmd *cobra.Command) error {
+	ws := viper.GetString("workspace")
+	socketPath := daemon.SocketPath(config.WorkspacePath(ws))
+	policy := connectionErrorPolicy()
+
+	listener, err := daemon.Listen(socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+	defer listener.Close()
+
+	engine, err := workspaceEngine(cmd, ws)
+	if err != nil {
+		return err
+	}
+
+	dbPath := workspaceDatabasePath(ws, viper.GetString(fmt.Sprintf("%s.dbLocation", ws)))
+	client := NewDatabaseClient(
+		WithEngine(engine),
+		WithWorkspace(ws),
+		WithDatabasePath(dbPath),
+		WithLockTimeout(lockTimeout()),
+		InitDatabaseClient(),
+	)
+	db, err := OpenConnection(*client)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// Every query gets a fresh connection, so temp tables and SET options don't leak
+	// between callers. Attached databases and secrets are shared by all of them.
+	db.SetMaxIdleConns(0)
+
+	scripts, err := workspaceBootScripts(ws)
+	if err != nil {
+		return err
+	}
+	warm := &warmDatabase{db: db, scripts: scripts, attachments: map[connection.ConnectionConfig]*attachment{}}
+
+	connectionNames, _ := cmd.Flags().GetStringArray("connections")
+	for _, name := range workspace.WorkspaceDefaultConnections(ws) {
+		if !slices.Contains(connectionNames, name) {
+			connectionNames = append(connectionNames, name)
+		}
+	}
+	connections := make([]connection.ConnectionConfig, 0, len(connectionNames))
+	for _, name := range connectionNames {
+		conn, err := workspace.WorkspaceConnection(ws, name)
+		if err != nil {
+			return err
+		}
+		connections = append(connections, conn)
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	err = warm.boot(ctx, conn, connections, policy)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+	server := &daemon.Server{
+		Handler:     warm.run,
+		ExitCode:    ExitCode,
+		IdleTimeout: idleTimeout,
+		Connections: warm.attached,
+	}
+	slog.Info("Daemon listening", "workspace", ws, "socket", socketPath, "connections", warm.attached())
+	return server.Serve(listener, ws, dbPath)
+}
+
+// warmDatabase is the daemon's open database along with the connections booted on it.
+type warmDatabase struct {
+	db *sql.DB
+	// scripts are the workspace's boot SQL and macros, run on each query's connection once
+	// its connections are attached.
+	scripts []BootScript
+
+	mu          sync.Mutex
+	attachments map[connection.ConnectionConfig]*attachment
+}
+
+// attachment is a connection of the warm database, booted the first time a query needs it.
+// Its own lock means a slow ATTACH only holds up the queries that use that connection.
+type attachment struct {
+	mu     sync.Mutex
+	booted atomic.Bool
+}
+
+// boot attaches the connections that haven't been already, then runs the boot scripts.
+// Attached databases and secrets are shared by every connection, so they are booted once.
+// Views and the TEMP macros and views of boot scripts only exist on the connection that
+// creates them, so view connections and boot scripts run for every query.
+func (w *warmDatabase) boot(ctx context.Context, conn *sql.Conn, connections []connection.ConnectionConfig, policy string) error {
+	exec := func(query string) error {
+		_, err := conn.ExecContext(ctx, query)
+		return err
+	}
+
+	for _, c := range connections {
+		if err := w.bootConnection(c, exec); err != nil {
+			if policy != ConnectionErrorSkip {
+				return err
+			}
+			stderrLog.Warn("Skipping connection that failed to boot", "connection", c.Name, "error", err)
+		}
+	}
+
+	if err := runBootScripts(w.scripts, exec); err != nil {
+		if policy != ConnectionErrorSkip {
+			return err
+		}
+		stderrLog.Warn("Skipping boot SQL that failed", "error", err)
+	}
+	return nil
+}
+
+// bootConnection boots c unless it is already attached to the warm database.
+func (w *warmDatabase) bootConnection(c connection.ConnectionConfig, exec func(string) error) error {
+	w.mu.Lock()
+	a, ok := w.attachments[c]
+	if !ok {
+		a = &attachment{}
+		w.attachments[c] = a
+	}
+	w.mu.Unlock()
+
+	// A view is created on the query's own connection, so there is nothing to wait for
+	if t, ok := connection.LookupType(c.Type); !ok || t.Kind() != connection.KindView {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.booted.Load() {
+			return nil
+		}
+	}
+
+	slog.Debug("Setting up connection", "name", c.Name, "type", c.Type, "readOrWrite", c.ReadWriteMode())
+	if err := c.Boot(exec); err != nil {
+		return err
+	}
+	a.booted.Store(true)
+	return nil
+}
+
+// attached returns the names of the connections booted so far.
+func (w *warmDatabase) attached() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	names := []string{}
+	for c, a := range w.attachments {
+		if a.booted.Load() {
+			names = append(names, c.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// run is the daemon's handler for forwarded scripts.
+func (w *warmDatabase) run(ctx context.Context, q daemon.Query, out io.Writer) error {
+	statements := script.Split(q.SQL)
+	if len(statements) == 0 {
+		return errors.New("no SQL statements to run")
+	}
+
+	conn, err := w.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := w.boot(ctx, conn, q.Connections, q.OnConnectionError); err != nil {
+		return err
+	}
+
+	// Read relative paths from the caller's directory rather than the daemon's
+	if q.Dir != "" {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET SESSION file_search_path = '%s'", strings.ReplaceAll(q.Dir, "'", "''"))); err != nil {
+			return err
+		}
+	}
+
+	return runStatements(ctx, conn, statements, q.Params, scriptOptions{
+		Format:     q.Format,
+		Options:    q.Options,
+		AllResults: q.AllResults,
+		File:       q.File,
+	}, out)
+}
+
+// forwardScript runs the script on the workspace's daemon, if one is running. It reports
+// false when there is no daemon, so the caller runs the script itself.
+func forwardScript(cmd *cobra.Command, ws string, src string, connectionNames []string, params param.Params, opts scriptOptions) (bool, error) {
+	client := daemonClient(ws)
+	connections := make([]connection.ConnectionConfig, 0, len(connectionNames))
+	for _, name := range connectionNames {
+		conn, err := workspace.WorkspaceConnection(ws, name)
+		if err != nil {
+			return true, err
+		}
+		connections = append(connections, conn)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return true, err
+	}
+	if opts.File.Path != "" && !filepath.IsAbs(opts.File.Path) {
+		opts.File.Path = filepath.Join(dir, opts.File.Path)
+	}
+
+	slog.Debug("Forwarding query to daemon", "socket", client.Path)
+	err = client.Query(daemon.Query{
+		SQL:               src,
+		Dir:               dir,
+		Connections:       connections,
+		OnConnectionError: connectionErrorPolicy(),
+		Params:            params,
+		Format:            opts.Format,
+		Options:           opts.Options,
+		AllResults:        opts.AllResults,
+		File:              opts.File,
+	}, cmd.OutOrStdout())
+	if errors.Is(err, daemon.ErrNotRunning) {
+		return false, nil
+	}
+	return true, err
+}
+
+func writeDaemonStatus(w io.Writer, status daemon.Status) error {
+	idleTimeout := "none"
+	if status.IdleTimeout > 0 {
+		idleTimeout = status.IdleTimeout.String()
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"pid":          status.PID,
+		"workspace":    status.Workspace,
+		"database":     status.Database,
+		"started":      status.Started.Format(time.RFC3339),
+		"last_used":    status.LastUsed.Format(time.RFC3339),
+		"requests":     status.Requests,
+		"connections":  status.Connections,
+		"idle_timeout": idleTimeout,
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package cmd
+
+import "syscall"
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
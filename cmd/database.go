@@ -4,28 +4,93 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/SandwichLabs/duck-tape/config"
 	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/SandwichLabs/duck-tape/redact"
 	"github.com/SandwichLabs/duck-tape/workspace"
 	"github.com/marcboeker/go-duckdb"
 	"github.com/spf13/cobra"
-	"golang.org/x/exp/slog"
+	"github.com/spf13/viper"
 )
 
 type DatabaseClient struct {
 	config Config
 	*duckdb.Connector
+	// err holds a failure from InitDatabaseClient until OpenConnection reports it.
+	err  error
+	boot *bootState
+	// readOnlyLock is the lock that made OpenConnection fall back to opening the database
+	// read-only, nil if it didn't.
+	readOnlyLock *LockError
+}
+
+// Policies for a connection that fails to boot.
+const (
+	// ConnectionErrorFail aborts the command with the connection's error.
+	ConnectionErrorFail = "fail"
+	// ConnectionErrorSkip logs a warning and carries on without the connection.
+	ConnectionErrorSkip = "skip"
+)
+
+// bootState tracks the connections skipped under ConnectionErrorSkip. Boot runs once per
+// pooled connection, so this is shared between them.
+type bootState struct {
+	mu      sync.Mutex
+	skipped map[string]error
+}
+
+func (b *bootState) skip(name string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.skipped[name] = err
+}
+
+// warnOnce logs boot SQL that failed under ConnectionErrorSkip, once rather than for every
+// pooled connection.
+func (b *bootState) warnOnce(what string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.skipped[what]; ok {
+		return
+	}
+	b.skipped[what] = err
+	stderrLog.Warn("Skipping boot SQL that failed", "error", err)
+}
+
+func (b *bootState) isSkipped(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.skipped[name]
+	return ok
 }
 
 type Config struct {
-	NumThreads   int
+	Engine       EngineSettings
 	Plugins      []string
 	Connections  []connection.ConnectionConfig
 	DatabasePath string
 	Workspace    string
 	BootQueries  []string
+	// BootScripts run after BootQueries. A failing script doesn't stop the others from running.
+	BootScripts []BootScript
+	Stdin       *StdinTable
+	// OnConnectionError is ConnectionErrorFail or ConnectionErrorSkip.
+	OnConnectionError string
+	// LockTimeout is how long to wait for another process to release the database.
+	LockTimeout time.Duration
+	// ReadOnlyFallback reports whether the database may be opened read-only when another
+	// process has it locked. It's only called once the database turns out to be locked.
+	ReadOnlyFallback func() bool
 }
 
 func NewDatabaseClient(options ...func(*DatabaseClient)) *DatabaseClient {
@@ -38,7 +103,14 @@ func NewDatabaseClient(options ...func(*DatabaseClient)) *DatabaseClient {
 
 func WithNumThreads(num int) func(*DatabaseClient) {
 	return func(c *DatabaseClient) {
-		c.config.NumThreads = num
+		c.config.Engine.Threads = num
+	}
+}
+
+// Open the database with the given engine settings, see workspaceEngine.
+func WithEngine(settings EngineSettings) func(*DatabaseClient) {
+	return func(c *DatabaseClient) {
+		c.config.Engine = settings
 	}
 }
 
@@ -48,23 +120,28 @@ func WithPlugins(plugins []string) func(*DatabaseClient) {
 	}
 }
 
-// Setup the connectionConfig with the connection details, plugins.
+// Setup the connectionConfig with the connection details.
 func WithConnectionsByName(connectionNames []string) func(*DatabaseClient) {
 	return func(c *DatabaseClient) {
 
 		connectionConfigs := []connection.ConnectionConfig{}
-		pluginsList := []string{}
 
 		for _, connection_name := range connectionNames {
 			conn, err := workspace.WorkspaceConnection(c.config.Workspace, connection_name)
 			cobra.CheckErr(err)
 
 			connectionConfigs = append(connectionConfigs, conn)
-			pluginsList = append(pluginsList, conn.Type)
 		}
 
-		c.config.Plugins = pluginsList
-		c.config.Connections = connectionConfigs
+		// Each connection installs and loads its own extensions when it boots
+		c.config.Connections = append(c.config.Connections, connectionConfigs...)
+	}
+}
+
+// Attach connections that aren't saved in the workspace, in addition to any added by name.
+func WithConnections(connections []connection.ConnectionConfig) func(*DatabaseClient) {
+	return func(c *DatabaseClient) {
+		c.config.Connections = append(c.config.Connections, connections...)
 	}
 }
 
@@ -80,64 +157,270 @@ func WithDatabasePath(path string) func(*DatabaseClient) {
 	}
 }
 
+// Set what happens when a connection fails to boot, see ConnectionErrorFail and ConnectionErrorSkip.
+func WithConnectionErrorPolicy(policy string) func(*DatabaseClient) {
+	return func(c *DatabaseClient) {
+		c.config.OnConnectionError = policy
+	}
+}
+
+// Wait up to timeout for another process to release the database, see openConnector.
+func WithLockTimeout(timeout time.Duration) func(*DatabaseClient) {
+	return func(c *DatabaseClient) {
+		c.config.LockTimeout = timeout
+	}
+}
+
+// Open the database read-only when another process has it locked and readOnly reports
+// that the command doesn't write to it.
+func WithReadOnlyFallback(readOnly func() bool) func(*DatabaseClient) {
+	return func(c *DatabaseClient) {
+		c.config.ReadOnlyFallback = readOnly
+	}
+}
+
 func WithBootQueries(queries []string) func(*DatabaseClient) {
 	return func(c *DatabaseClient) {
 		c.config.BootQueries = queries
 	}
 }
 
+// Run the workspace's boot SQL and macros folder on every connection, see workspaceBootScripts.
+func WithWorkspaceBoot() func(*DatabaseClient) {
+	return func(c *DatabaseClient) {
+		scripts, err := workspaceBootScripts(c.config.Workspace)
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.config.BootScripts = append(c.config.BootScripts, scripts...)
+	}
+}
+
+func WithBootScripts(scripts []BootScript) func(*DatabaseClient) {
+	return func(c *DatabaseClient) {
+		c.config.BootScripts = append(c.config.BootScripts, scripts...)
+	}
+}
+
+// Expose the data read from r as a temporary view with the given name on every connection.
+func WithStdin(name string, format string, r io.Reader) func(*DatabaseClient) {
+	return func(c *DatabaseClient) {
+		table, err := spoolStdin(name, format, r)
+		cobra.CheckErr(err)
+
+		c.config.Stdin = &table
+	}
+}
+
+// RemoveTempFiles deletes any files spooled while setting up the client.
+func (c *DatabaseClient) RemoveTempFiles() {
+	if c.config.Stdin != nil {
+		os.Remove(c.config.Stdin.Path)
+	}
+}
+
 func InitDatabaseClient() func(*DatabaseClient) {
 	slog.Debug("Initializing database client")
 	return func(c *DatabaseClient) {
 
 		slog.Debug("c.config.Connections", "connections", c.config.Connections)
-		databasePath := c.config.DatabasePath
-		if databasePath == "dt.db" || databasePath == "" {
-			// If the database path is not set, we use the default workspace database in /home/.dt/workspace_name/dt.db
-			databasePath = fmt.Sprintf("%s/dt.db", config.WorkspacePath(c.config.Workspace))
+		databasePath := workspaceDatabasePath(c.config.Workspace, c.config.DatabasePath)
+
+		if c.boot == nil {
+			c.boot = &bootState{skipped: map[string]error{}}
 		}
 
-		connString := fmt.Sprintf("%s?threads=%d", databasePath, c.config.NumThreads)
-		slog.Debug("Creating DuckDB connector", "connString", connString)
-		connector, err := duckdb.NewConnector(connString, func(execer driver.ExecerContext) error {
-			var bootQueries []string
+		engine := c.config.Engine
+		if engine.ReadOnly && isInMemory(databasePath) {
+			// DuckDB can't open an in-memory database read-only, and there's nothing to protect
+			slog.Debug("Ignoring read-only setting for an in-memory database", "path", databasePath)
+			engine.ReadOnly = false
+		}
+		boot := func(execer driver.ExecerContext) error {
+			exec := func(query string) error {
+				slog.Debug("Running boot query", "query", redact.String(query))
+				_, err := execer.ExecContext(context.Background(), query, nil)
+				return err
+			}
 
 			for _, plugin := range c.config.Plugins {
-				bootQueries = append(bootQueries, fmt.Sprintf("INSTALL '%s'", plugin))
-				bootQueries = append(bootQueries, fmt.Sprintf("LOAD '%s'", plugin))
+				if err := exec(fmt.Sprintf("INSTALL '%s'", plugin)); err != nil {
+					return &connection.ExtensionError{Extension: plugin, Op: "install", Err: err}
+				}
+				if err := exec(fmt.Sprintf("LOAD '%s'", plugin)); err != nil {
+					return &connection.ExtensionError{Extension: plugin, Op: "load", Err: err}
+				}
+			}
+
+			for _, statement := range c.config.Engine.bootStatements() {
+				if err := exec(statement); err != nil {
+					return fmt.Errorf("applying engine settings: %w", err)
+				}
 			}
 
 			for _, attachment := range c.config.Connections {
-				slog.Debug("Attaching connection", "attachment", attachment)
+				if c.boot.isSkipped(attachment.Name) {
+					continue
+				}
 
 				slog.Debug("Setting up connection", "name", attachment.Name, "type", attachment.Type, "readOrWrite", attachment.ReadWriteMode())
+				err := attachment.Boot(exec)
+				if err == nil {
+					continue
+				}
+				if c.config.OnConnectionError != ConnectionErrorSkip {
+					return err
+				}
+				// Remember the failure so the connections the pool opens later don't retry it
+				c.boot.skip(attachment.Name, err)
+				stderrLog.Warn("Skipping connection that failed to boot", "connection", attachment.Name, "error", err)
+			}
 
-				bootQueries = append(bootQueries, fmt.Sprintf("ATTACH '%s' as %s (TYPE %s %s);", attachment.ConnString, attachment.Name, attachment.Type, attachment.ReadWriteMode()))
+			if c.config.Stdin != nil {
+				if err := exec(c.config.Stdin.bootQuery()); err != nil {
+					return fmt.Errorf("exposing stdin as %s: %w", c.config.Stdin.Name, err)
+				}
 			}
 
-			slog.Debug("Executing boot queries", "connections", c.config.Connections)
+			for _, query := range c.config.BootQueries {
+				if err := exec(query); err != nil {
+					return fmt.Errorf("running boot query: %w", err)
+				}
+			}
 
-			for _, query := range bootQueries {
-				slog.Debug("Running boot query", "query", query)
-				_, errs := execer.ExecContext(context.Background(), query, nil)
-				if errs != nil {
-					slog.Error("Error running initial boot setup", "Error", errs)
+			if err := runBootScripts(c.config.BootScripts, exec); err != nil {
+				if c.config.OnConnectionError != ConnectionErrorSkip {
+					return err
 				}
-				slog.Debug("Executed boot query", "query", query)
+				c.boot.warnOnce("boot SQL", err)
 			}
 			return nil
-		})
+		}
 
+		connector, err := c.openConnector(databasePath, engine, boot)
+		if lockErr, ok := asLockError(databasePath, err); ok {
+			c.err = lockErr
+			return
+		}
 		if err != nil {
-			slog.Error("Error initializing database client", err)
+			c.err = fmt.Errorf("opening database %s: %w", databasePath, err)
+			return
 		}
 
 		c.Connector = connector
 	}
 }
 
+// openConnector opens the database, waiting with backoff for up to the lock timeout while
+// another process has it locked. With a read-only fallback it opens the database read-only
+// as soon as it is locked, which succeeds when the other processes only read it too.
+func (c *DatabaseClient) openConnector(path string, engine EngineSettings, boot func(driver.ExecerContext) error) (*duckdb.Connector, error) {
+	backoff := newLockBackoff(c.config.LockTimeout)
+	waiting := false
+	// The fallback is only considered once, the first time the database is locked
+	fallbackChecked := engine.ReadOnly || c.config.ReadOnlyFallback == nil
+	for {
+		connString := engine.connString(path)
+		slog.Debug("Creating DuckDB connector", "connString", connString)
+		connector, err := duckdb.NewConnector(connString, boot)
+		lockErr, locked := asLockError(path, err)
+		if !locked {
+			return connector, err
+		}
+		if !fallbackChecked {
+			fallbackChecked = true
+			if c.config.ReadOnlyFallback() {
+				stderrLog.Info("Database is locked, opening it read-only", "path", path, "holder", lockErr.Holder, "pid", lockErr.PID)
+				engine.ReadOnly = true
+				c.readOnlyLock = lockErr
+				continue
+			}
+		}
+		if !waiting && c.config.LockTimeout > 0 {
+			stderrLog.Info("Waiting for database lock", "path", path, "holder", lockErr.Holder, "pid", lockErr.PID, "timeout", c.config.LockTimeout.String())
+			waiting = true
+		}
+		if !backoff.wait() {
+			return nil, lockErr
+		}
+	}
+}
+
+// InMemory is the database path DuckDB opens in memory, discarded when dt exits.
+const InMemory = ":memory:"
+
+// addDatabaseFlags registers the flags that pick the database a command opens instead of
+// the workspace's.
+func addDatabaseFlags(c *cobra.Command) {
+	c.Flags().Bool("memory", false, "Run against an in-memory database instead of the workspace database (connections are still attached)")
+	c.Flags().String("db", "", "Run against this DuckDB file instead of the workspace database")
+	c.MarkFlagsMutuallyExclusive("memory", "db")
+}
+
+// databaseFlagsChanged reports whether --memory or --db picked a database other than the workspace's.
+func databaseFlagsChanged(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("memory") || cmd.Flags().Changed("db")
+}
+
+// commandDatabasePath returns the database a command opens: in memory with --memory, the
+// file given with --db, or the workspace's dbLocation.
+func commandDatabasePath(cmd *cobra.Command, ws string) (string, error) {
+	if memory, _ := cmd.Flags().GetBool("memory"); memory {
+		if readOnly, _ := cmd.Flags().GetBool("readonly"); readOnly {
+			return "", errors.New("--readonly can't be used with --memory")
+		}
+		return InMemory, nil
+	}
+	if path, _ := cmd.Flags().GetString("db"); path != "" {
+		return filepath.Abs(expandHome(path))
+	}
+	return workspaceDatabasePath(ws, viper.GetString(fmt.Sprintf("%s.dbLocation", ws))), nil
+}
+
+// workspaceDatabasePath returns the database for a workspace given its configured dbLocation:
+// the workspace's dt.db by default, a DuckDB in-memory path as is, or a file path with ~
+// expanded.
+func workspaceDatabasePath(workspace string, dbLocation string) string {
+	switch {
+	case dbLocation == "dt.db" || dbLocation == "":
+		// If the database path is not set, we use the default workspace database in /home/.dt/workspace_name/dt.db
+		return filepath.Join(config.WorkspacePath(workspace), "dt.db")
+	case isInMemory(dbLocation):
+		return dbLocation
+	}
+	return expandHome(dbLocation)
+}
+
+// isInMemory reports whether a database path is in memory, either :memory: or a named
+// in-memory database such as :memory:scratch.
+func isInMemory(path string) bool {
+	return strings.HasPrefix(path, InMemory)
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// OpenConnection opens the client's database and boots a first connection, so that
+// extension and attach failures are reported here rather than by the first query.
 func OpenConnection(conn DatabaseClient) (*sql.DB, error) {
+	if conn.err != nil {
+		return nil, conn.err
+	}
+
 	db := sql.OpenDB(conn.Connector)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return db, nil
 }
 
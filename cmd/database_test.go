@@ -1,10 +1,26 @@
 package cmd_test
 
 import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/SandwichLabs/duck-tape/cmd"
+	"github.com/SandwichLabs/duck-tape/config"
+	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestOpen(t *testing.T) {
@@ -12,7 +28,7 @@ func TestOpen(t *testing.T) {
 	client := cmd.NewDatabaseClient(
 		cmd.WithNumThreads(4),
 		cmd.WithWorkspace("test_workspace"),
-		cmd.WithDatabasePath("test.db"),
+		cmd.WithDatabasePath(filepath.Join(t.TempDir(), "test.db")),
 		cmd.InitDatabaseClient(),
 	)
 
@@ -39,7 +55,7 @@ func TestPrepare(t *testing.T) {
 	client := cmd.NewDatabaseClient(
 		cmd.WithNumThreads(4),
 		cmd.WithWorkspace("test_workspace"),
-		cmd.WithDatabasePath("test.db"),
+		cmd.WithDatabasePath(filepath.Join(t.TempDir(), "test.db")),
 		cmd.InitDatabaseClient(),
 	)
 
@@ -65,3 +81,403 @@ func TestPrepare(t *testing.T) {
 	}
 	assert.Equal(t, 1, count)
 }
+
+func TestWithStdin(t *testing.T) {
+	client := cmd.NewDatabaseClient(
+		cmd.WithNumThreads(4),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(filepath.Join(t.TempDir(), "test.db")),
+		cmd.WithStdin("piped", "auto", strings.NewReader("{\"id\": 1}\n{\"id\": 2}\n")),
+		cmd.InitDatabaseClient(),
+	)
+	defer client.RemoveTempFiles()
+
+	db, err := cmd.OpenConnection(*client)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var total int
+	err = db.QueryRow("SELECT sum(id) FROM piped").Scan(&total)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+}
+
+func TestReferencesRelation(t *testing.T) {
+	assert.True(t, cmd.ReferencesRelation("select * from stdin", "stdin"))
+	assert.True(t, cmd.ReferencesRelation(`select * from "STDIN" limit 1`, "stdin"))
+	assert.False(t, cmd.ReferencesRelation("select * from stdin_backup", "stdin"))
+	assert.False(t, cmd.ReferencesRelation("select * from pg.stdin", "stdin"))
+}
+
+func TestOpenReportsBootErrors(t *testing.T) {
+	client := cmd.NewDatabaseClient(
+		cmd.WithNumThreads(4),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(filepath.Join(t.TempDir(), "test.db")),
+		cmd.WithBootQueries([]string{"SELECT * FROM missing_table"}),
+		cmd.InitDatabaseClient(),
+	)
+
+	// Boot failures surface when opening rather than on the first query
+	_, err := cmd.OpenConnection(*client)
+	assert.ErrorContains(t, err, "missing_table")
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, cmd.ExitOK, cmd.ExitCode(nil))
+	assert.Equal(t, cmd.ExitError, cmd.ExitCode(errors.New("syntax error")))
+	assert.Equal(t, cmd.ExitExtension, cmd.ExitCode(&connection.ExtensionError{Connection: "pg", Extension: "postgres", Op: "load", Err: errors.New("boom")}))
+	assert.Equal(t, cmd.ExitAttach, cmd.ExitCode(fmt.Errorf("opening: %w", &connection.AttachError{Connection: "pg", Err: errors.New("boom")})))
+	assert.Equal(t, cmd.ExitAuth, cmd.ExitCode(&connection.AuthError{Connection: "pg", Err: errors.New("boom")}))
+	assert.Equal(t, cmd.ExitLocked, cmd.ExitCode(&cmd.LockError{Path: "dt.db", Holder: "dt", PID: 42, Err: errors.New("boom")}))
+}
+
+func TestStorageConnection(t *testing.T) {
+	probe, err := sql.Open("duckdb", "")
+	require.NoError(t, err)
+	defer probe.Close()
+	if _, err := probe.Exec("INSTALL httpfs; LOAD httpfs"); err != nil {
+		t.Skipf("httpfs extension unavailable: %v", err)
+	}
+
+	// A local stand-in for S3: path style GETs of a single object
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket/data.csv" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "data.csv", time.Time{}, strings.NewReader("id,name\n1,duck\n2,tape\n"))
+	}))
+	defer s3.Close()
+
+	client := cmd.NewDatabaseClient(
+		cmd.WithNumThreads(4),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(filepath.Join(t.TempDir(), "test.db")),
+		cmd.WithConnections([]connection.ConnectionConfig{{
+			Name:       "lake",
+			Type:       "S3",
+			ConnString: fmt.Sprintf("key_id=test secret=test region=us-east-1 endpoint=%s url_style=path use_ssl=false", strings.TrimPrefix(s3.URL, "http://")),
+		}}),
+		cmd.InitDatabaseClient(),
+	)
+
+	db, err := cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var count int
+	err = db.QueryRow("SELECT count(*) FROM read_csv('s3://bucket/data.csv')").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestAttachDuckDBFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "other.duckdb")
+	other, err := sql.Open("duckdb", path)
+	require.NoError(t, err)
+	_, err = other.Exec("CREATE TABLE ducks AS SELECT * FROM range(3) t(id)")
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+
+	client := cmd.NewDatabaseClient(
+		cmd.WithNumThreads(4),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(filepath.Join(t.TempDir(), "test.db")),
+		cmd.WithConnections([]connection.ConnectionConfig{{Name: "other", Type: "DUCKDB", ConnString: path}}),
+		cmd.InitDatabaseClient(),
+	)
+
+	db, err := cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var count int
+	err = db.QueryRow("SELECT count(*) FROM other.ducks").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Attached read-only unless writes are enabled
+	_, err = db.Exec("INSERT INTO other.ducks VALUES (4)")
+	assert.Error(t, err)
+}
+
+// rangeType is a third party connection type exposing range(n) as a view.
+type rangeType struct {
+	connection.BaseType
+}
+
+func (rangeType) BootStatements(c connection.ConnectionConfig) []string {
+	return []string{fmt.Sprintf("CREATE OR REPLACE TEMP VIEW %s AS SELECT * FROM range(%s) t(n);", c.Name, c.ConnString)}
+}
+
+func TestRegisteredType(t *testing.T) {
+	connection.Register(rangeType{connection.BaseType{
+		TypeName:   "RANGE",
+		TypeLabel:  "Numbers",
+		TypeKind:   connection.KindView,
+		ConnFormat: connection.FormatPath,
+		FormFields: []connection.Field{{Key: "count", Title: "Count", Required: true}},
+		Requires:   []string{},
+	}})
+
+	conn := connection.ConnectionConfig{Name: "numbers", Type: "range", ConnString: "5"}
+	require.NoError(t, conn.Validate())
+
+	client := cmd.NewDatabaseClient(
+		cmd.WithNumThreads(4),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(filepath.Join(t.TempDir(), "test.db")),
+		cmd.WithConnections([]connection.ConnectionConfig{conn}),
+		cmd.InitDatabaseClient(),
+	)
+
+	db, err := cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var sum int
+	err = db.QueryRow("SELECT sum(n) FROM numbers").Scan(&sum)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, sum)
+}
+
+func TestQueryReferences(t *testing.T) {
+	refs := cmd.QueryReferences("SELECT * FROM pg.public.orders JOIN read_parquet('s3://bucket/x.parquet') USING (id)")
+	assert.True(t, refs.HasName("pg"))
+	assert.True(t, refs.HasName("orders"))
+	assert.True(t, refs.HasPathPrefix("s3://bucket/"))
+	assert.False(t, refs.HasName("mysql"))
+
+	// Statements json_serialize_sql can't handle are scanned for words instead
+	refs = cmd.QueryReferences("INSERT INTO warehouse.events SELECT * FROM 'gs://logs/today.csv'")
+	assert.True(t, refs.HasName("warehouse"))
+	assert.True(t, refs.HasPathPrefix("gs://"))
+
+	lake := connection.ConnectionConfig{Name: "lake", Type: "S3", ConnString: "scope=s3://bucket"}
+	assert.True(t, lake.ReferencedBy(cmd.QueryReferences("SELECT * FROM 's3://bucket/a.csv'")))
+	assert.False(t, lake.ReferencedBy(cmd.QueryReferences("SELECT * FROM 's3://other/a.csv'")))
+	assert.False(t, lake.ReferencedBy(cmd.QueryReferences("SELECT 1 AS lake")))
+}
+
+func TestWorkspaceBoot(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	macros := filepath.Join(config.WorkspacePath("test_workspace"), "macros")
+	require.NoError(t, os.MkdirAll(macros, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(macros, "math.sql"), []byte("CREATE OR REPLACE TEMP MACRO add_one(x) AS x + 1;\nCREATE OR REPLACE TEMP MACRO double(x) AS x * 2;"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(macros, "notes.txt"), []byte("not sql"), 0644))
+
+	viper.Set("test_workspace.boot", []string{"SET VARIABLE start_at = 10"})
+	t.Cleanup(viper.Reset)
+
+	client := cmd.NewDatabaseClient(
+		cmd.WithNumThreads(4),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(":memory:"),
+		cmd.WithWorkspaceBoot(),
+		cmd.InitDatabaseClient(),
+	)
+	db, err := cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var result int
+	err = db.QueryRow("SELECT double(add_one(getvariable('start_at')))").Scan(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, 22, result)
+
+	// Every broken file is reported, with the line of the failing statement
+	require.NoError(t, os.WriteFile(filepath.Join(macros, "a_broken.sql"), []byte("SELECT 1;\n\nSELECT * FROM missing_a;"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(macros, "z_broken.sql"), []byte("SELECT * FROM missing_z;"), 0644))
+
+	client = cmd.NewDatabaseClient(
+		cmd.WithNumThreads(4),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(":memory:"),
+		cmd.WithWorkspaceBoot(),
+		cmd.InitDatabaseClient(),
+	)
+	_, err = cmd.OpenConnection(*client)
+	assert.ErrorContains(t, err, "a_broken.sql (line 3)")
+	assert.ErrorContains(t, err, "missing_a")
+	assert.ErrorContains(t, err, "z_broken.sql (line 1)")
+
+	// A persistent CREATE would fail on the next start, so it's refused before it runs
+	require.NoError(t, os.Remove(filepath.Join(macros, "a_broken.sql")))
+	require.NoError(t, os.Remove(filepath.Join(macros, "z_broken.sql")))
+	require.NoError(t, os.WriteFile(filepath.Join(macros, "views.sql"), []byte("CREATE VIEW IF NOT EXISTS kept AS SELECT 1;\nCREATE MACRO triple(x) AS x * 3;"), 0644))
+
+	client = cmd.NewDatabaseClient(
+		cmd.WithNumThreads(4),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(":memory:"),
+		cmd.WithWorkspaceBoot(),
+		cmd.InitDatabaseClient(),
+	)
+	_, err = cmd.OpenConnection(*client)
+	assert.ErrorContains(t, err, "views.sql (line 2)")
+	assert.ErrorContains(t, err, "TEMP, OR REPLACE or IF NOT EXISTS")
+}
+
+func TestEngineSettings(t *testing.T) {
+	preserve := false
+	client := cmd.NewDatabaseClient(
+		cmd.WithEngine(cmd.EngineSettings{
+			Threads:                2,
+			MemoryLimit:            "1GB",
+			PreserveInsertionOrder: &preserve,
+			DefaultOrder:           "desc",
+		}),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(":memory:"),
+		cmd.InitDatabaseClient(),
+	)
+	db, err := cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var threads int64
+	var memoryLimit, preserveOrder, defaultOrder string
+	err = db.QueryRow("SELECT current_setting('threads'), current_setting('memory_limit'), current_setting('preserve_insertion_order')::VARCHAR, current_setting('default_order')").
+		Scan(&threads, &memoryLimit, &preserveOrder, &defaultOrder)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), threads)
+	assert.NotEmpty(t, memoryLimit)
+	assert.Equal(t, "false", preserveOrder)
+	assert.Equal(t, "desc", strings.ToLower(defaultOrder))
+
+	// A read-only database can be queried but not changed
+	path := filepath.Join(t.TempDir(), "test.db")
+	client = cmd.NewDatabaseClient(cmd.WithDatabasePath(path), cmd.InitDatabaseClient())
+	db, err = cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t AS SELECT 1 AS id")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	client = cmd.NewDatabaseClient(
+		cmd.WithEngine(cmd.EngineSettings{ReadOnly: true}),
+		cmd.WithDatabasePath(path),
+		cmd.InitDatabaseClient(),
+	)
+	db, err = cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var id int
+	require.NoError(t, db.QueryRow("SELECT id FROM t").Scan(&id))
+	assert.Equal(t, 1, id)
+	_, err = db.Exec("INSERT INTO t VALUES (2)")
+	assert.ErrorContains(t, err, "read-only")
+}
+
+func TestInMemoryDatabase(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// A workspace's read_only setting doesn't stop --memory from working
+	client := cmd.NewDatabaseClient(
+		cmd.WithEngine(cmd.EngineSettings{ReadOnly: true}),
+		cmd.WithWorkspace("test_workspace"),
+		cmd.WithDatabasePath(cmd.InMemory),
+		cmd.InitDatabaseClient(),
+	)
+	db, err := cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE t AS SELECT 1 AS id")
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(config.WorkspacePath("test_workspace"), "dt.db"))
+	assert.True(t, os.IsNotExist(err), "the workspace database isn't created")
+}
+
+// TestHoldLock isn't a test: TestDatabaseLock runs it in another process to hold a lock
+// on the database in DT_HOLD_LOCK until its stdin is closed.
+func TestHoldLock(t *testing.T) {
+	path := os.Getenv("DT_HOLD_LOCK")
+	if path == "" {
+		t.Skip("only run by TestDatabaseLock")
+	}
+	client := cmd.NewDatabaseClient(
+		cmd.WithEngine(cmd.EngineSettings{ReadOnly: os.Getenv("DT_HOLD_LOCK_READ_ONLY") != ""}),
+		cmd.WithDatabasePath(path),
+		cmd.InitDatabaseClient(),
+	)
+	db, err := cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	defer db.Close()
+
+	fmt.Println("locked")
+	_, _ = io.Copy(io.Discard, os.Stdin)
+}
+
+// holdLock opens the database in another process and returns once it holds the lock.
+func holdLock(t *testing.T, path string, readOnly bool) *exec.Cmd {
+	holder := exec.Command(os.Args[0], "-test.run=^TestHoldLock$", "-test.v")
+	holder.Env = append(os.Environ(), "DT_HOLD_LOCK="+path)
+	if readOnly {
+		holder.Env = append(holder.Env, "DT_HOLD_LOCK_READ_ONLY=1")
+	}
+	stdin, err := holder.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := holder.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, holder.Start())
+	t.Cleanup(func() {
+		stdin.Close()
+		holder.Wait()
+	})
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if scanner.Text() == "locked" {
+			return holder
+		}
+	}
+	t.Fatal("the lock holder exited without locking the database")
+	return nil
+}
+
+func TestDatabaseLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	client := cmd.NewDatabaseClient(cmd.WithDatabasePath(path), cmd.InitDatabaseClient())
+	db, err := cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t AS SELECT 1 AS id")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// A writer locks out everyone else, and the error names it
+	writer := holdLock(t, path, false)
+	start := time.Now()
+	client = cmd.NewDatabaseClient(
+		cmd.WithDatabasePath(path),
+		cmd.WithLockTimeout(300*time.Millisecond),
+		cmd.WithReadOnlyFallback(func() bool { return true }),
+		cmd.InitDatabaseClient(),
+	)
+	_, err = cmd.OpenConnection(*client)
+	var lockErr *cmd.LockError
+	require.ErrorAs(t, err, &lockErr)
+	assert.Equal(t, writer.Process.Pid, lockErr.PID)
+	assert.ErrorContains(t, err, fmt.Sprintf("(PID %d)", writer.Process.Pid))
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond, "retries until the lock timeout")
+
+	writer.Process.Kill()
+	writer.Wait()
+
+	// Readers share the database, so a read-only query falls back to opening it read-only
+	holdLock(t, path, true)
+	client = cmd.NewDatabaseClient(cmd.WithDatabasePath(path), cmd.InitDatabaseClient())
+	_, err = cmd.OpenConnection(*client)
+	require.ErrorAs(t, err, &lockErr)
+
+	client = cmd.NewDatabaseClient(cmd.WithDatabasePath(path), cmd.WithReadOnlyFallback(func() bool { return true }), cmd.InitDatabaseClient())
+	db, err = cmd.OpenConnection(*client)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var id int
+	require.NoError(t, db.QueryRow("SELECT id FROM t").Scan(&id))
+	assert.Equal(t, 1, id)
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/SandwichLabs/duck-tape/output"
+	"github.com/SandwichLabs/duck-tape/param"
+	"github.com/SandwichLabs/duck-tape/script"
+	"github.com/spf13/cobra"
+)
+
+// eachRecord is one input record queued for a worker.
+type eachRecord struct {
+	number int
+	line   int
+	params param.Params
+	// err is set when the record was malformed, which fails it like a query error.
+	err error
+}
+
+// eachResult collects the rows a record produced so they can be written without interleaving.
+type eachResult struct {
+	columns []output.Column
+	rows    [][]interface{}
+}
+
+// batchRunner executes one statement per input record, preparing each distinct
+// rewrite of the statement once per connection.
+type batchRunner struct {
+	statement script.Statement
+	base      param.Params
+
+	mu        sync.Mutex
+	formatter output.Formatter
+	header    bool
+}
+
+// runEach runs statement once for every record read from the --each input.
+func runEach(ctx context.Context, cmd *cobra.Command, db *sql.DB, statement script.Statement, base param.Params, formatter output.Formatter) error {
+	path, _ := cmd.Flags().GetString("each")
+	format, _ := cmd.Flags().GetString("each-format")
+	workers, _ := cmd.Flags().GetInt("workers")
+	failFast, _ := cmd.Flags().GetBool("fail-fast")
+	useTransaction, _ := cmd.Flags().GetBool("transaction")
+
+	var input io.Reader
+	if path == "-" {
+		input = cmd.InOrStdin()
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open --each input: %w", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	if format == "" {
+		var err error
+		if format, err = param.InferRecordFormat(path); err != nil {
+			return err
+		}
+	}
+	records, err := param.NewRecordReader(input, format)
+	if err != nil {
+		return err
+	}
+
+	// A failed statement aborts a DuckDB transaction, so the batch can't continue past it.
+	if useTransaction {
+		if workers > 1 {
+			stderrLog.Warn("Running with a single worker inside a transaction", "workers", workers)
+		}
+		workers = 1
+		failFast = true
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	runner := &batchRunner{statement: statement, base: base, formatter: formatter}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var tx *sql.Tx
+	if useTransaction {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	queue := make(chan eachRecord)
+	var processed, failed atomic.Int64
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var execer statementPreparer = tx
+			if tx == nil {
+				conn, err := db.Conn(ctx)
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					cancel()
+					return
+				}
+				defer conn.Close()
+				execer = conn
+			}
+
+			prepared := map[string]*sql.Stmt{}
+			defer func() {
+				for _, stmt := range prepared {
+					stmt.Close()
+				}
+			}()
+
+			for record := range queue {
+				err := record.err
+				if err == nil {
+					err = runner.run(ctx, execer, prepared, record)
+				}
+				processed.Add(1)
+				if err == nil {
+					continue
+				}
+				failed.Add(1)
+				stderrLog.Error("Record failed", "record", record.number, "line", record.line, "error", err)
+				if failFast {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("record %d (line %d) failed: %w", record.number, record.line, err)
+					})
+					cancel()
+				}
+			}
+		}()
+	}
+
+	readErr := func() error {
+		defer close(queue)
+		for number := 1; ; number++ {
+			params, line, err := records.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			record := eachRecord{number: number, line: line, params: params}
+			var recordErr *param.RecordError
+			if errors.As(err, &recordErr) {
+				record.err = err
+			} else if err != nil {
+				return err
+			}
+			select {
+			case queue <- record:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}()
+	wg.Wait()
+
+	if readErr != nil && firstErr == nil {
+		firstErr = fmt.Errorf("failed to read --each input: %w", readErr)
+	}
+
+	// Close off the output even on failure so that partial results stay well formed
+	flushErr := runner.flush()
+
+	if tx != nil {
+		if firstErr != nil {
+			if err := tx.Rollback(); err != nil {
+				stderrLog.Error("Rollback failed", "error", err)
+			}
+			return firstErr
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	if flushErr != nil {
+		return flushErr
+	}
+
+	slog.Debug("Processed records", "records", processed.Load(), "failed", failed.Load())
+	if failed.Load() > 0 {
+		return fmt.Errorf("%d of %d records failed", failed.Load(), processed.Load())
+	}
+	return nil
+}
+
+// statementPreparer is satisfied by both *sql.Conn and *sql.Tx.
+type statementPreparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+func (b *batchRunner) run(ctx context.Context, execer statementPreparer, prepared map[string]*sql.Stmt, record eachRecord) error {
+	params, err := b.base.Merge(record.params)
+	if err != nil {
+		return err
+	}
+	query, args, err := params.Bind(b.statement)
+	if err != nil {
+		return err
+	}
+
+	stmt, ok := prepared[query]
+	if !ok {
+		stmt, err = execer.PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		prepared[query] = stmt
+	}
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	result, err := collectRows(rows)
+	if err != nil {
+		return err
+	}
+	return b.write(result)
+}
+
+// write passes a record's rows to the shared formatter, writing the header before the first one.
+func (b *batchRunner) write(result eachResult) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.header {
+		if err := b.formatter.WriteHeader(result.columns); err != nil {
+			return err
+		}
+		b.header = true
+	}
+	for _, row := range result.rows {
+		if err := b.formatter.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *batchRunner) flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.header {
+		if err := b.formatter.WriteHeader(nil); err != nil {
+			return err
+		}
+	}
+	return b.formatter.Flush()
+}
+
+func collectRows(rows *sql.Rows) (eachResult, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return eachResult{}, err
+	}
+	result := eachResult{columns: make([]output.Column, len(columnTypes))}
+	for i, ct := range columnTypes {
+		result.columns[i] = output.Column{Name: ct.Name(), Type: ct.DatabaseTypeName()}
+	}
+
+	for rows.Next() {
+		values := make([]interface{}, len(columnTypes))
+		scanArgs := make([]interface{}, len(values))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+		if err := rows.Scan(scanArgs...); err != nil {
+			return eachResult{}, err
+		}
+		result.rows = append(result.rows, values)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// EngineSettings tune the DuckDB instance a workspace database is opened with. Zero
+// values leave DuckDB's defaults, e.g. threads defaults to the number of cores.
+type EngineSettings struct {
+	Threads                int
+	MemoryLimit            string
+	TempDirectory          string
+	MaxTempDirectorySize   string
+	PreserveInsertionOrder *bool
+	// DefaultOrder is asc or desc.
+	DefaultOrder string
+	// TimeZone needs the icu extension, so it is SET once the connection is up rather than
+	// passed when opening the database.
+	TimeZone string
+	// ReadOnly opens the database in read-only mode, so several processes can use it. It is
+	// ignored for in-memory databases.
+	ReadOnly bool
+}
+
+// engineFlags are the global flags for each engine setting, named as in the engine section.
+var engineFlags = []struct {
+	key   string
+	flag  string
+	usage string
+}{
+	{"threads", "threads", "Number of DuckDB worker threads (default is the number of cores)"},
+	{"memory_limit", "memory-limit", "DuckDB memory limit, e.g. 16GB or 80%"},
+	{"temp_directory", "temp-directory", "Directory DuckDB spills to when a query doesn't fit in memory"},
+	{"max_temp_directory_size", "max-temp-directory-size", "Maximum size of the temp directory, e.g. 100GB"},
+	{"preserve_insertion_order", "preserve-insertion-order", "Keep rows in insertion order when no ORDER BY is given (false lowers memory use)"},
+	{"default_order", "default-order", "Default ORDER BY direction (asc or desc)"},
+	{"timezone", "timezone", "Time zone for TIMESTAMPTZ values, e.g. UTC (needs the icu extension)"},
+	{"read_only", "readonly", "Open the database read-only"},
+}
+
+func addEngineFlags(c *cobra.Command) {
+	flags := c.PersistentFlags()
+	for _, f := range engineFlags {
+		switch f.key {
+		case "threads":
+			flags.Int(f.flag, 0, f.usage)
+		case "preserve_insertion_order", "read_only":
+			flags.Bool(f.flag, false, f.usage)
+		default:
+			flags.String(f.flag, "", f.usage)
+		}
+	}
+}
+
+// engineSetting returns the value of an engine setting from its flag, the workspace's
+// engine section or the top level engine section shared by every workspace, in that order.
+func engineSetting(cmd *cobra.Command, ws string, key string, flag string) (string, bool) {
+	if f := cmd.Flags().Lookup(flag); f != nil && f.Changed {
+		return f.Value.String(), true
+	}
+	for _, prefix := range []string{ws + ".engine.", "engine."} {
+		if viper.IsSet(prefix + key) {
+			return viper.GetString(prefix + key), true
+		}
+	}
+	return "", false
+}
+
+// workspaceEngine reads the engine settings for a workspace, see engineSetting.
+func workspaceEngine(cmd *cobra.Command, ws string) (EngineSettings, error) {
+	var settings EngineSettings
+	for _, f := range engineFlags {
+		value, ok := engineSetting(cmd, ws, f.key, f.flag)
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch f.key {
+		case "threads":
+			settings.Threads, err = strconv.Atoi(value)
+			if err == nil && settings.Threads < 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "memory_limit":
+			settings.MemoryLimit = value
+		case "temp_directory":
+			settings.TempDirectory = value
+		case "max_temp_directory_size":
+			settings.MaxTempDirectorySize = value
+		case "preserve_insertion_order":
+			var preserve bool
+			preserve, err = strconv.ParseBool(value)
+			settings.PreserveInsertionOrder = &preserve
+		case "default_order":
+			settings.DefaultOrder = strings.ToLower(value)
+			if settings.DefaultOrder != "asc" && settings.DefaultOrder != "desc" {
+				err = fmt.Errorf("expected asc or desc")
+			}
+		case "timezone":
+			settings.TimeZone = value
+		case "read_only":
+			settings.ReadOnly, err = strconv.ParseBool(value)
+		}
+		if err != nil {
+			return EngineSettings{}, fmt.Errorf("invalid engine setting %s %q: %w", f.key, value, err)
+		}
+	}
+	return settings, nil
+}
+
+// dsnOptions are the settings passed in the connector string, applied as the database opens.
+func (e EngineSettings) dsnOptions() url.Values {
+	options := url.Values{}
+	if e.Threads > 0 {
+		options.Set("threads", strconv.Itoa(e.Threads))
+	}
+	if e.MemoryLimit != "" {
+		options.Set("memory_limit", e.MemoryLimit)
+	}
+	if e.TempDirectory != "" {
+		options.Set("temp_directory", e.TempDirectory)
+	}
+	if e.MaxTempDirectorySize != "" {
+		options.Set("max_temp_directory_size", e.MaxTempDirectorySize)
+	}
+	if e.PreserveInsertionOrder != nil {
+		options.Set("preserve_insertion_order", strconv.FormatBool(*e.PreserveInsertionOrder))
+	}
+	if e.DefaultOrder != "" {
+		options.Set("default_order", e.DefaultOrder)
+	}
+	if e.ReadOnly {
+		options.Set("access_mode", "READ_ONLY")
+	}
+	return options
+}
+
+// bootStatements are the settings that can only be SET once a connection is up.
+func (e EngineSettings) bootStatements() []string {
+	if e.TimeZone == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("SET TimeZone = '%s';", strings.ReplaceAll(e.TimeZone, "'", "''"))}
+}
+
+// connString is the connector string for a database file opened with these settings.
+func (e EngineSettings) connString(databasePath string) string {
+	options := e.dsnOptions()
+	if len(options) == 0 {
+		return databasePath
+	}
+	return databasePath + "?" + options.Encode()
+}
+
+// engineFlagsChanged reports whether any engine setting was given on the command line.
+func engineFlagsChanged(cmd *cobra.Command) bool {
+	for _, f := range engineFlags {
+		if cmd.Flags().Changed(f.flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// engineArgs are the engine flags given on the command line, to pass on to a child dt.
+func engineArgs(cmd *cobra.Command) []string {
+	var args []string
+	for _, f := range engineFlags {
+		if flag := cmd.Flags().Lookup(f.flag); flag != nil && flag.Changed {
+			args = append(args, fmt.Sprintf("--%s=%s", f.flag, flag.Value.String()))
+		}
+	}
+	return args
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/SandwichLabs/duck-tape/daemon"
+	"github.com/SandwichLabs/duck-tape/redact"
+	"github.com/spf13/cobra"
+)
+
+// Process exit codes, so scripts can tell a broken connection apart from a bad query.
+const (
+	ExitOK    = 0
+	ExitError = 1
+	// ExitExtension means an extension a connection needs couldn't be installed or loaded.
+	ExitExtension = 3
+	// ExitAttach means a connection couldn't be attached, e.g. the host is unreachable.
+	ExitAttach = 4
+	// ExitAuth means a connection's credentials were rejected.
+	ExitAuth = 5
+	// ExitLocked means another process had the database locked for longer than --lock-timeout.
+	ExitLocked = 6
+)
+
+// ExitCode maps an error to the process exit code dt reports for it.
+func ExitCode(err error) int {
+	var extensionErr *connection.ExtensionError
+	var attachErr *connection.AttachError
+	var authErr *connection.AuthError
+	var remoteErr *daemon.RemoteError
+	var lockErr *LockError
+
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &remoteErr):
+		// Mapped by the daemon, which has the original error
+		return remoteErr.Code
+	case errors.As(err, &authErr):
+		return ExitAuth
+	case errors.As(err, &attachErr):
+		return ExitAttach
+	case errors.As(err, &extensionErr):
+		return ExitExtension
+	case errors.As(err, &lockErr):
+		return ExitLocked
+	default:
+		return ExitError
+	}
+}
+
+// checkErr is cobra.CheckErr with credentials redacted and the exit code taken from ExitCode.
+func checkErr(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Error:", redact.String(err.Error()))
+	os.Exit(ExitCode(err))
+}
+
+// connectionErrorPolicy reads and validates --on-connection-error.
+func connectionErrorPolicy() string {
+	policy := globalSetting("on_connection_error")
+	if policy != ConnectionErrorFail && policy != ConnectionErrorSkip {
+		cobra.CheckErr(fmt.Errorf("invalid --on-connection-error %q, expected %s or %s", policy, ConnectionErrorFail, ConnectionErrorSkip))
+	}
+	return policy
+}
+
+// lockTimeout reads and validates --lock-timeout.
+func lockTimeout() time.Duration {
+	value := globalSetting("lock_timeout")
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		cobra.CheckErr(fmt.Errorf("invalid --lock-timeout %q, expected a duration such as 10s", value))
+	}
+	return timeout
+}
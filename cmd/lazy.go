@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/SandwichLabs/duck-tape/script"
+	"github.com/SandwichLabs/duck-tape/workspace"
+)
+
+var (
+	parserOnce sync.Once
+	parser     *sql.DB
+	parserErr  error
+)
+
+// parserDatabase returns an in-memory database for running json_serialize_sql, opened
+// once and shared by the whole process.
+func parserDatabase() (*sql.DB, error) {
+	parserOnce.Do(func() {
+		parser, parserErr = sql.Open("duckdb", "")
+	})
+	return parser, parserErr
+}
+
+// QueryReferences returns the names and paths used by the statements in src. SELECT
+// statements are parsed by DuckDB with json_serialize_sql. Other statements, and anything
+// DuckDB can't parse without the catalogs attached, fall back to the identifiers and
+// string literals in the SQL, which may over-match but never misses a reference.
+func QueryReferences(src string) connection.References {
+	var refs connection.References
+
+	db, err := parserDatabase()
+	if err != nil {
+		slog.Debug("Falling back to scanning the query for references", "error", err)
+		db = nil
+	}
+
+	for _, statement := range script.Split(src) {
+		if db != nil && serializedReferences(db, statement.SQL, &refs) {
+			continue
+		}
+		identifiers, literals := script.Words(statement.SQL)
+		refs.Names = append(refs.Names, identifiers...)
+		refs.Paths = append(refs.Paths, literals...)
+	}
+	return refs
+}
+
+// serializedReferences adds the tables and paths in DuckDB's parse tree of sql to refs. It
+// reports false when DuckDB can't serialize the statement.
+func serializedReferences(db *sql.DB, sql string, refs *connection.References) bool {
+	var serialized string
+	err := db.QueryRowContext(context.Background(), "select json_serialize_sql(?::VARCHAR)::VARCHAR", sql).Scan(&serialized)
+	if err != nil {
+		slog.Debug("json_serialize_sql failed", "error", err)
+		return false
+	}
+
+	var tree any
+	if err := json.Unmarshal([]byte(serialized), &tree); err != nil {
+		return false
+	}
+	if root, ok := tree.(map[string]any); ok && root["error"] == true {
+		return false
+	}
+
+	walkSerialized(tree, refs)
+	return true
+}
+
+func walkSerialized(node any, refs *connection.References) {
+	switch node := node.(type) {
+	case map[string]any:
+		if node["type"] == "BASE_TABLE" {
+			for _, key := range []string{"catalog_name", "schema_name", "table_name"} {
+				if name, ok := node[key].(string); ok && name != "" {
+					refs.Names = append(refs.Names, name)
+				}
+			}
+		}
+		for _, child := range node {
+			walkSerialized(child, refs)
+		}
+	case []any:
+		for _, child := range node {
+			walkSerialized(child, refs)
+		}
+	case string:
+		if strings.Contains(node, "://") {
+			refs.Paths = append(refs.Paths, node)
+		}
+	}
+}
+
+// referencedDefaultConnections returns the workspace's default connections that the
+// query in src needs, leaving out those already attached by name.
+func referencedDefaultConnections(ws string, src string, attached []string) []string {
+	var candidates []connection.ConnectionConfig
+	for _, name := range workspace.WorkspaceDefaultConnections(ws) {
+		if slices.Contains(attached, name) {
+			continue
+		}
+		conn, err := workspace.WorkspaceConnection(ws, name)
+		if err != nil {
+			slog.Warn("Default connection not found in workspace", "connection", name)
+			continue
+		}
+		candidates = append(candidates, conn)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	refs := QueryReferences(src)
+	var names []string
+	for _, conn := range candidates {
+		if conn.ReferencedBy(refs) {
+			slog.Debug("Attaching default connection referenced by the query", "connection", conn.Name)
+			names = append(names, conn.Name)
+		}
+	}
+	return names
+}
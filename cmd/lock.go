@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SandwichLabs/duck-tape/script"
+)
+
+// DefaultLockTimeout is how long dt waits for another process to release the database.
+const DefaultLockTimeout = 5 * time.Second
+
+// Backoff between attempts to open a locked database.
+const (
+	lockRetryMin = 50 * time.Millisecond
+	lockRetryMax = time.Second
+)
+
+// LockError means another process has the database file open. DuckDB allows one process
+// to write to a file, or any number of processes to read it.
+type LockError struct {
+	Path string
+	// Holder is the program holding the lock and PID its process ID, 0 if DuckDB didn't say.
+	Holder string
+	PID    int
+	// Daemon is the workspace whose dt daemon holds the lock, if any.
+	Daemon string
+	Err    error
+}
+
+func (e *LockError) Error() string {
+	if e.Daemon != "" {
+		return fmt.Sprintf("database %s is held by the dt daemon for workspace %q (PID %d), which can't run commands with piped stdin, --each, --no-daemon or engine flags; use --memory or --db instead, or stop it with dt daemon stop", e.Path, e.Daemon, e.PID)
+	}
+	holder := "another process"
+	if e.PID != 0 {
+		holder = fmt.Sprintf("%s (PID %d)", e.Holder, e.PID)
+	}
+	return fmt.Sprintf("database %s is locked by %s; wait longer with --lock-timeout, open it with --readonly if both only read, or run dt daemon start so queries share one process", e.Path, holder)
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+var lockConflict = regexp.MustCompile(`Could not set lock on file "[^"]*": Conflicting lock is held in (.*?) \(PID (\d+)\)`)
+
+// asLockError returns a LockError if err is DuckDB failing to lock the database file.
+func asLockError(path string, err error) (*LockError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	var lockErr *LockError
+	if errors.As(err, &lockErr) {
+		return lockErr, true
+	}
+	match := lockConflict.FindStringSubmatch(err.Error())
+	if match == nil {
+		return nil, false
+	}
+	pid, _ := strconv.Atoi(match[2])
+	return &LockError{Path: path, Holder: match[1], PID: pid, Err: err}, true
+}
+
+// withLockHolder notes on lockErr when the process holding the lock is the workspace's daemon.
+func withLockHolder(ws string, lockErr *LockError) *LockError {
+	status, err := daemonClient(ws).Status()
+	if err != nil || lockErr.PID == 0 || status.PID != lockErr.PID {
+		return lockErr
+	}
+	held := *lockErr
+	held.Daemon = ws
+	return &held
+}
+
+// daemonLock returns a LockError when the workspace's daemon has the database at dbPath
+// open. A command that can't go through the daemon would otherwise wait for the lock until
+// --lock-timeout, though the daemon holds it for as long as it runs.
+func daemonLock(ws string, dbPath string) *LockError {
+	status, err := daemonClient(ws).Status()
+	if err != nil || status.Database != dbPath {
+		return nil
+	}
+	return &LockError{Path: dbPath, Holder: "dt daemon", PID: status.PID, Daemon: ws}
+}
+
+// lockBackoff waits before the next attempt to open a locked database, doubling the wait
+// each time. It reports false once the deadline has passed.
+type lockBackoff struct {
+	deadline time.Time
+	delay    time.Duration
+}
+
+func newLockBackoff(timeout time.Duration) *lockBackoff {
+	return &lockBackoff{deadline: time.Now().Add(timeout), delay: lockRetryMin}
+}
+
+func (b *lockBackoff) wait() bool {
+	remaining := time.Until(b.deadline)
+	if remaining <= 0 {
+		return false
+	}
+	time.Sleep(min(b.delay, remaining))
+	b.delay = min(b.delay*2, lockRetryMax)
+	return true
+}
+
+// writingFunctions are functions that write to the database even when called from a SELECT.
+var writingFunctions = []string{"nextval", "setval"}
+
+// readOnlyStatements reports whether every statement only reads the database, so the script
+// can run on a database opened read-only. Only SELECT statements (including FROM, VALUES,
+// DESCRIBE, SHOW and SUMMARIZE) count, as those are the ones json_serialize_sql accepts, and
+// not ones calling a sequence function. A table function or macro that writes can't be told
+// apart from one that reads, so the script fails on the read-only database and
+// readOnlyFailure reports the lock instead.
+func readOnlyStatements(statements []script.Statement) bool {
+	db, err := parserDatabase()
+	if err != nil {
+		return false
+	}
+
+	for _, statement := range statements {
+		identifiers, _ := script.Words(statement.SQL)
+		for _, identifier := range identifiers {
+			if slices.ContainsFunc(writingFunctions, func(name string) bool { return strings.EqualFold(name, identifier) }) {
+				return false
+			}
+		}
+		var serialized string
+		err := db.QueryRowContext(context.Background(), "select json_serialize_sql(?::VARCHAR)::VARCHAR", statement.SQL).Scan(&serialized)
+		if err != nil {
+			slog.Debug("json_serialize_sql failed", "error", err)
+			return false
+		}
+		var result struct {
+			Error bool `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(serialized), &result); err != nil || result.Error {
+			return false
+		}
+	}
+	return true
+}
+
+// readOnlyFailure returns the lock that made the client open the database read-only when err
+// is the script trying to write to it, as the lock is the real cause. Otherwise it returns err.
+func readOnlyFailure(ws string, client *DatabaseClient, err error) error {
+	if err == nil || client.readOnlyLock == nil || !strings.Contains(err.Error(), "read-only mode") {
+		return err
+	}
+	return withLockHolder(ws, client.readOnlyLock)
+}
@@ -1,13 +1,24 @@
 package cmd
 
 import (
+	"io"
 	"log/slog"
 	"os"
+
+	"github.com/SandwichLabs/duck-tape/redact"
 )
 
+// SetJsonHandler logs JSON to stdout, with credentials redacted from every record.
 func SetJsonHandler(logLevel *slog.LevelVar) {
-	h := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
-	slog.SetDefault(h)
+	slog.SetDefault(newJsonLogger(os.Stdout, logLevel))
+}
+
+// stderrLog logs like the default logger, but to stderr. Warnings raised while a command
+// writes its results to stdout go here, so they can't end up inside the results.
+var stderrLog = newJsonLogger(os.Stderr, &logLevel)
+
+func newJsonLogger(w io.Writer, logLevel *slog.LevelVar) *slog.Logger {
+	return slog.New(redact.NewHandler(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel})))
 }
 
 func SetLogLevel(stringLevel string, logLevel *slog.LevelVar) {
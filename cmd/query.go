@@ -5,10 +5,22 @@ package cmd
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/output"
+	"github.com/SandwichLabs/duck-tape/param"
+	"github.com/SandwichLabs/duck-tape/savedquery"
+	"github.com/SandwichLabs/duck-tape/script"
+	"github.com/SandwichLabs/duck-tape/workspace"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"log/slog"
 )
 
 // transformCmd represents the transform command
@@ -24,95 +36,408 @@ var queryCmd = &cobra.Command{
 
 	With parameters:
 	dt query "select * from test where id = ?;" -p 1
+	dt query "select * from test where id = $id and created > $since;" -p id:int=1 -p since:timestamp=2024-01-01T00:00:00Z
+	dt query "select * from test where list_contains($ids, id);" -p ids:int[]=1,2,3
+	dt query "select * from test where name is not distinct from $name;" -p name:null
+	dt query "select * from test where id = $id;" --params-file params.json
+
+	With a different output format (ndjson, json, csv, tsv, markdown, table):
+	dt query "select * from test;" --format csv
+
+	Writing results to a file (format inferred from .parquet, .csv, .json, .ndjson):
+	dt query "select * from test;" -o results.parquet
+	dt query "select * from test;" -o results.csv.gz
+	dt query "select * from test;" -o out_dir --output-format parquet --partition-by name
+
+	Querying piped data (csv, tsv, ndjson, json or parquet, detected automatically):
+	cat data.csv | dt q "select * from stdin"
+	cat events.ndjson | dt q "select * from events" --stdin-name events --stdin-format ndjson
+
+	Running a query once per input record (fields bind to named parameters):
+	dt query "insert into users values ($id, $name);" --each users.ndjson --transaction
+	dt query "select * from pg.orders where user_id = $id;" --each ids.csv --workers 4 -c pg
+
+	Reading SQL from a file or stdin (multiple statements run in order, the last result is printed):
+	dt query -f script.sql
+	echo "select 42;" | dt query -
+
+	Saving a query to run again later (saved once it runs, or without running when it has
+	parameters and none are given):
+	dt query "select count(*) from test;" --save test_count
+	dt query "select * from test where id = $id;" --save test_by_id
+	dt run test_by_id -p 1
 
 	With connections:
 	dt create connection
 	dt query "select * from connectionName.test;" -c connectionName 
 	
 	`,
-	Args: cobra.ExactArgs(1),
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		workspace := viper.GetString("workspace")
+		src, err := querySource(cmd, args)
+		cobra.CheckErr(err)
 
-		dbPath := viper.GetString(fmt.Sprintf("%s.dbLocation", workspace))
-		slog.Debug("Database path:", "dbPath", dbPath)
+		saveName, _ := cmd.Flags().GetString("save")
+		if saveName != "" && needsParams(cmd, src) {
+			// There's nothing to bind the placeholders to yet, so only save the query
+			checkErr(saveQuery(cmd, saveName, src))
+			stderrLog.Info("Saved query, run it with dt run and its parameters", "name", saveName, "params", script.ParamNames(src))
+			return
+		}
 
-		query := args[0]
+		err = runScript(cmd, scriptSource{
+			SQL:       src,
+			ReadStdin: len(args) == 0 || args[0] != "-",
+		})
+		if err == nil && saveName != "" {
+			// Only queries that ran are saved
+			err = saveQuery(cmd, saveName, src)
+		}
+		checkErr(err)
+	},
+}
 
-		connectionNames, _ := cmd.Flags().GetStringArray("connections")
+// needsParams reports whether src has placeholders but no parameters were given to bind them.
+func needsParams(cmd *cobra.Command, src string) bool {
+	for _, flag := range []string{"param", "params-file", "each"} {
+		if cmd.Flags().Changed(flag) {
+			return false
+		}
+	}
+	for _, statement := range script.Split(src) {
+		if statement.HasParams {
+			return true
+		}
+	}
+	return false
+}
 
-		client := NewDatabaseClient(
-			WithNumThreads(4),
-			WithWorkspace(workspace),
-			WithDatabasePath(dbPath),
-			WithConnectionsByName(connectionNames),
-			InitDatabaseClient(),
-		)
+// saveQuery saves src to the workspace under name, with the connections given with -c.
+func saveQuery(cmd *cobra.Command, name string, src string) error {
+	connectionNames, _ := cmd.Flags().GetStringArray("connections")
+	query := savedquery.New(name, src, connectionNames)
+	slog.Debug("Saving query", "query", query)
+	_, err := workspace.SetWorkspaceQuery(viper.GetString("workspace"), query, true)
+	return err
+}
 
-		db, err := OpenConnection(*client)
+func init() {
+	rootCmd.AddCommand(queryCmd)
+	addQueryFlags(queryCmd)
+	queryCmd.Flags().StringP("file", "f", "", "Read the SQL to run from a file")
+	// Optionally save the query to the workspace for later use with dt run <name>
+	queryCmd.Flags().StringP("save", "s", "", "Save the query to the workspace under this name")
+}
 
-		cobra.CheckErr(err)
+// addQueryFlags registers the flags shared by every command that runs SQL.
+func addQueryFlags(c *cobra.Command) {
+	c.Flags().StringArrayP("connections", "c", []string{}, "One or more connection configurations to attach")
+	c.Flags().StringArrayP("param", "p", []string{}, "One or more parameters to pass to the query: value, name=value, name:type=value or name:null")
+	c.Flags().String("params-file", "", "Read parameters from a JSON object (named) or array (positional)")
+	c.Flags().StringP("format", "F", "ndjson", fmt.Sprintf("Output format (%s)", strings.Join(output.Names(), ", ")))
+	c.Flags().Bool("header", true, "Include a header row in csv and tsv output")
+	c.Flags().Bool("decimals-as-numbers", false, "Write DECIMAL values as exact JSON numbers instead of strings")
+	c.Flags().Bool("all-results", false, "Print the result of every statement in a script instead of only the last one")
+	c.Flags().String("stdin-name", "stdin", "Name of the relation piped stdin data is exposed as")
+	c.Flags().String("stdin-format", "auto", "Format of piped stdin data (auto, csv, tsv, ndjson, json, parquet)")
+	c.Flags().String("each", "", "Run the query once per record of an NDJSON or CSV file (- for stdin), binding its fields as named parameters")
+	c.Flags().String("each-format", "", "Format of the --each input (ndjson, csv, tsv), inferred from the extension by default")
+	c.Flags().Int("workers", 1, "Number of records to run concurrently with --each (results may be written out of order)")
+	c.Flags().Bool("transaction", false, "Run every --each record in a single transaction, rolled back on the first failure")
+	c.Flags().Bool("fail-fast", false, "Stop at the first failing --each record instead of reporting it and continuing")
+	c.Flags().StringP("output", "o", "", "Write results to a file with DuckDB COPY instead of stdout")
+	c.Flags().String("output-format", "", "Output file format (parquet, csv, tsv, json, ndjson), inferred from the extension by default")
+	c.Flags().String("compression", "", "Output file compression (none, gzip, zstd, snappy for parquet)")
+	c.Flags().Int("row-group-size", 0, "Parquet row group size in rows")
+	c.Flags().StringArray("partition-by", []string{}, "Write hive-style partitioned output by one or more columns")
+	c.Flags().Bool("no-daemon", false, "Open the database in this process even when a dt daemon is running for the workspace")
+	addDatabaseFlags(c)
+}
 
-		defer db.Close()
+// querySource returns the SQL passed as an argument, read from --file, or read from stdin when the argument is "-".
+func querySource(cmd *cobra.Command, args []string) (string, error) {
+	file, _ := cmd.Flags().GetString("file")
 
-		stmt, err := db.PrepareContext(context.Background(), query)
+	switch {
+	case file != "" && len(args) > 0:
+		return "", errors.New("pass either a query or --file, not both")
+	case file != "":
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read SQL file: %w", err)
+		}
+		return string(src), nil
+	case len(args) == 0:
+		return "", errors.New("a query, --file or - (read from stdin) is required")
+	case args[0] == "-":
+		src, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return "", fmt.Errorf("failed to read SQL from stdin: %w", err)
+		}
+		return string(src), nil
+	default:
+		return args[0], nil
+	}
+}
 
-		cobra.CheckErr(err)
-		queryParams, err := cmd.Flags().GetStringArray("param")
+// scriptSource is the SQL for runScript along with where it came from.
+type scriptSource struct {
+	SQL string
+	// Connections are attached in addition to any passed with -c.
+	Connections []string
+	// ReadStdin allows piped stdin to be exposed as a relation. It is false when the SQL itself came from stdin.
+	ReadStdin bool
+}
 
-		cobra.CheckErr(err)
+// runScript splits the source into statements and runs them in order on a single connection,
+// printing the last result set (or every one with --all-results).
+func runScript(cmd *cobra.Command, source scriptSource) error {
+	src := source.SQL
+	statements := script.Split(src)
+	if len(statements) == 0 {
+		return errors.New("no SQL statements to run")
+	}
+
+	workspace := viper.GetString("workspace")
 
-		interfaceParams := make([]interface{}, len(queryParams))
+	dbPath, err := commandDatabasePath(cmd, workspace)
+	if err != nil {
+		return err
+	}
+	slog.Debug("Database path:", "dbPath", dbPath)
 
-		for i, v := range queryParams {
-			interfaceParams[i] = v
+	connectionNames, _ := cmd.Flags().GetStringArray("connections")
+	for _, name := range source.Connections {
+		if !slices.Contains(connectionNames, name) {
+			connectionNames = append(connectionNames, name)
 		}
+	}
+	// Default connections are only attached when the SQL uses them
+	connectionNames = append(connectionNames, referencedDefaultConnections(workspace, src, connectionNames)...)
 
-		rows, err := stmt.QueryContext(context.Background(), interfaceParams...)
+	opts := scriptOptionsFromFlags(cmd)
 
-		cobra.CheckErr(err)
+	// Validate the format before opening the database
+	if _, err := output.New(opts.Format, io.Discard, opts.Options); err != nil {
+		return err
+	}
 
-		// Get column names
-		columns, err := rows.Columns()
-		if err != nil {
-			panic(err.Error())
+	params, err := queryParams(cmd)
+	if err != nil {
+		return err
+	}
+
+	stdinName, _ := cmd.Flags().GetString("stdin-name")
+	readStdin := source.ReadStdin && StdinIsPiped() && ReferencesRelation(src, stdinName)
+	eachPath, _ := cmd.Flags().GetString("each")
+
+	engine, err := workspaceEngine(cmd, workspace)
+	if err != nil {
+		return err
+	}
+
+	// A running daemon already has the database open and its connections attached, with
+	// the engine settings it was started with
+	noDaemon, _ := cmd.Flags().GetBool("no-daemon")
+	canForward := !noDaemon && !readStdin && eachPath == "" && !engineFlagsChanged(cmd) && !databaseFlagsChanged(cmd)
+	if canForward {
+		forwarded, err := forwardScript(cmd, workspace, src, connectionNames, params, opts)
+		if forwarded {
+			return err
+		}
+	} else if lockErr := daemonLock(workspace, dbPath); lockErr != nil {
+		return lockErr
+	}
+
+	options := []func(*DatabaseClient){
+		WithEngine(engine),
+		WithWorkspace(workspace),
+		WithDatabasePath(dbPath),
+		WithConnectionsByName(connectionNames),
+		WithConnectionErrorPolicy(connectionErrorPolicy()),
+		WithWorkspaceBoot(),
+		WithLockTimeout(lockTimeout()),
+	}
+	options = append(options, WithReadOnlyFallback(func() bool { return readOnlyStatements(statements) }))
+
+	if readStdin {
+		stdinFormat, _ := cmd.Flags().GetString("stdin-format")
+		options = append(options, WithStdin(stdinName, stdinFormat, cmd.InOrStdin()))
+	}
+
+	client := NewDatabaseClient(append(options, InitDatabaseClient())...)
+	defer client.RemoveTempFiles()
+
+	db, err := OpenConnection(*client)
+	if lockErr, ok := asLockError(dbPath, err); ok {
+		// The workspace's daemon may have started since, and holding the lock itself
+		if canForward {
+			forwarded, err := forwardScript(cmd, workspace, src, connectionNames, params, opts)
+			if forwarded {
+				return err
+			}
 		}
+		return withLockHolder(workspace, lockErr)
+	}
+	if err != nil {
+		return err
+	}
 
-		// Make a slice for the values
-		values := make([]interface{}, len(columns))
+	defer db.Close()
 
-		// rows.Scan wants '[]interface{}' as an argument, so we must copy the
-		// references into such a slice
-		// See http://code.google.com/p/go-wiki/wiki/InterfaceSlice for details
-		scanArgs := make([]interface{}, len(values))
-		for i := range values {
-			scanArgs[i] = &values[i]
+	if eachPath != "" {
+		if len(statements) != 1 {
+			return errors.New("--each runs a single statement, but the SQL contains several")
+		}
+		formatter, err := output.New(opts.Format, cmd.OutOrStdout(), opts.Options)
+		if err != nil {
+			return err
 		}
+		return readOnlyFailure(workspace, client, runEach(context.Background(), cmd, db, statements[0], params, formatter))
+	}
 
-		defer rows.Close()
-		// Fetch rows
-		for rows.Next() {
-			err = rows.Scan(scanArgs...)
+	// Every statement has to run on the same connection so that temp tables,
+	// SET options and transactions carry over between them.
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return readOnlyFailure(workspace, client, runStatements(ctx, conn, statements, params, opts, cmd.OutOrStdout()))
+}
+
+// scriptOptions control what runStatements does with the results of a script.
+type scriptOptions struct {
+	Format     string
+	Options    output.Options
+	AllResults bool
+	// File is set when the last result is written to a file instead of printed.
+	File output.FileOptions
+}
+
+func scriptOptionsFromFlags(cmd *cobra.Command) scriptOptions {
+	var opts scriptOptions
+	opts.Format, _ = cmd.Flags().GetString("format")
+	opts.Options.Header, _ = cmd.Flags().GetBool("header")
+	opts.Options.DecimalsAsNumbers, _ = cmd.Flags().GetBool("decimals-as-numbers")
+	opts.AllResults, _ = cmd.Flags().GetBool("all-results")
+
+	opts.File.Path, _ = cmd.Flags().GetString("output")
+	opts.File.Format, _ = cmd.Flags().GetString("output-format")
+	opts.File.Compression, _ = cmd.Flags().GetString("compression")
+	opts.File.RowGroupSize, _ = cmd.Flags().GetInt("row-group-size")
+	opts.File.PartitionBy, _ = cmd.Flags().GetStringArray("partition-by")
+	opts.File.Header = opts.Options.Header
+	return opts
+}
+
+// runStatements runs the statements in order on conn, writing the last result set (or
+// every one with AllResults) to w.
+func runStatements(ctx context.Context, conn *sql.Conn, statements []script.Statement, params param.Params, opts scriptOptions, w io.Writer) error {
+	for i, statement := range statements {
+		last := i == len(statements)-1
 
-			cobra.CheckErr(err)
-			// Make an interface slice to hold the values of each row that can be marshalled to JSON
-			valueMap := make(map[string]interface{})
-			for i, col := range values {
-				valueMap[columns[i]] = col
+		query, args, err := params.Bind(statement)
+		switch {
+		case err != nil:
+		case last && opts.File.Path != "":
+			err = copyStatement(ctx, conn, query, args, opts.File)
+		case last || opts.AllResults:
+			err = printStatement(ctx, conn, query, args, func() (output.Formatter, error) {
+				return output.New(opts.Format, w, opts.Options)
+			})
+		default:
+			err = printStatement(ctx, conn, query, args, nil)
+		}
+
+		if err != nil {
+			if len(statements) == 1 {
+				return err
 			}
+			return fmt.Errorf("statement %d (line %d) failed: %w", i+1, statement.Line, err)
+		}
+	}
 
-			valueString, err := ToJsonString(valueMap)
-			cobra.CheckErr(err)
+	return nil
+}
 
-			fmt.Fprintln(cmd.OutOrStdout(), valueString)
+// queryParams parses the --param values, merged over any --params-file.
+func queryParams(cmd *cobra.Command) (param.Params, error) {
+	var params param.Params
+
+	paramsFile, _ := cmd.Flags().GetString("params-file")
+	if paramsFile != "" {
+		file, err := os.Open(paramsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open params file: %w", err)
 		}
-	},
+		defer file.Close()
+
+		params, err = param.FromJSON(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	specs, err := cmd.Flags().GetStringArray("param")
+	if err != nil {
+		return nil, err
+	}
+	flagParams, err := param.ParseAll(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	return params.Merge(flagParams)
 }
 
-func init() {
-	rootCmd.AddCommand(queryCmd)
-	queryCmd.Flags().StringArrayP("connections", "c", []string{}, "One or more connection configurations to attach")
-	queryCmd.Flags().StringArrayP("param", "p", []string{}, "One or more parameters to pass to the query")
-	// Optionally save the query to the ducktape folder for later use
-	queryCmd.Flags().BoolP("save", "s", false, "Save the query to the ducktape folder")
+// printStatement runs query and writes its result set through the formatter returned by
+// newFormatter. When newFormatter is nil the result set is discarded.
+func printStatement(ctx context.Context, conn *sql.Conn, query string, params []interface{}, newFormatter func() (output.Formatter, error)) error {
+	stmt, err := conn.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if newFormatter == nil {
+		for rows.Next() {
+		}
+		return rows.Err()
+	}
+
+	formatter, err := newFormatter()
+	if err != nil {
+		return err
+	}
+	return output.WriteRows(formatter, rows)
+}
+
+// copyStatement writes the result of query to a file with a COPY statement.
+func copyStatement(ctx context.Context, conn *sql.Conn, query string, params []interface{}, file output.FileOptions) error {
+	copyQuery, err := output.CopyStatement(query, file)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := conn.PrepareContext(ctx, copyQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var written int64
+	if err := stmt.QueryRowContext(ctx, params...).Scan(&written); err != nil {
+		return err
+	}
+	slog.Info("Wrote query results", "path", file.Path, "rows", written)
+	return nil
 }
@@ -4,12 +4,15 @@ Copyright © 2024 Zac Orndorff zac@orndorff.dev
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/SandwichLabs/duck-tape/config"
+	"github.com/SandwichLabs/duck-tape/workspace"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"log/slog"
 	"os"
+	"path/filepath"
 )
 
 var cfgFile string
@@ -43,7 +46,7 @@ var rootCmd = &cobra.Command{
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(ExitCode(err))
 	}
 }
 
@@ -57,6 +60,27 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dt/.dt.yaml)")
 	err := viper.BindPFlag("workspace", rootCmd.PersistentFlags().Lookup("workspace"))
 	cobra.CheckErr(err)
+	// Not bound to viper, which would write the flag's default into the config file on the next save
+	rootCmd.PersistentFlags().String("on-connection-error", ConnectionErrorFail, "What to do when a connection fails to attach: fail the command, or skip the connection with a warning")
+	rootCmd.PersistentFlags().Duration("lock-timeout", DefaultLockTimeout, "How long to wait for another dt process to release the workspace database")
+	addEngineFlags(rootCmd)
+}
+
+// globalSettings are the dt settings that have a global flag, see globalSetting.
+var globalSettings = map[string]string{
+	"on_connection_error": "on-connection-error",
+	"lock_timeout":        "lock-timeout",
+}
+
+// globalSetting returns a global flag's value when it was given, else the setting from the
+// config file or environment, else the flag's default. The default isn't registered with
+// viper, so saving the config never persists it.
+func globalSetting(key string) string {
+	flag := rootCmd.PersistentFlags().Lookup(globalSettings[key])
+	if !flag.Changed && viper.IsSet(key) {
+		return viper.GetString(key)
+	}
+	return flag.Value.String()
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -96,6 +120,11 @@ func InitConfig() {
 		err = viper.ReadInConfig()
 		cobra.CheckErr(err)
 
+		// dt workspace use sets the workspace for commands without -w
+		if name := viper.GetString(workspace.DefaultWorkspaceKey); name != "" && !rootCmd.PersistentFlags().Changed("workspace") {
+			viper.Set("workspace", name)
+		}
+
 		config.EnsureWorkspace(configPath, viper.GetString("workspace"))
 
 		cobra.CheckErr(err)
@@ -107,4 +136,50 @@ func InitConfig() {
 	if err := viper.ReadInConfig(); err == nil {
 		slog.Debug("Using config file", "configFile", viper.ConfigFileUsed())
 	}
+
+	useProjectConfig()
+}
+
+// projectTrust is the record of the project files the user has trusted, see dt config trust.
+func projectTrust() workspace.ProjectTrust {
+	return workspace.ProjectTrust{Path: filepath.Join(config.GetConfigPath(), "trusted_projects.yaml")}
+}
+
+// useProjectConfig switches to the workspace defined by a .dt.yaml or dt.yaml in the
+// working directory or a parent, unless -w names a different one. Project files are only
+// used once trusted with dt config trust.
+func useProjectConfig() {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	path, ok := workspace.FindProject(cwd)
+	if !ok {
+		return
+	}
+
+	project, err := workspace.LoadProject(path)
+	cobra.CheckErr(err)
+	if rootCmd.PersistentFlags().Changed("workspace") && viper.GetString("workspace") != project.Workspace {
+		slog.Debug("Ignoring project file for another workspace", "project", path, "workspace", project.Workspace)
+		return
+	}
+
+	trust := projectTrust()
+	if err := trust.Check(project); err != nil {
+		if errors.Is(err, workspace.ErrProjectUntrusted) || errors.Is(err, workspace.ErrProjectChanged) {
+			if cmd, _, findErr := rootCmd.Find(os.Args[1:]); findErr != nil || (cmd != configTrustCmd && cmd != configUntrustCmd) {
+				stderrLog.Warn("Ignoring project file until it is trusted, review it and run dt config trust", "project", path, "reason", err)
+			}
+			return
+		}
+		cobra.CheckErr(err)
+	}
+	project.Trust = &trust
+
+	err = workspace.UseProject(project)
+	cobra.CheckErr(err)
+	SetLogLevel(viper.GetString("LOG_LEVEL"), &logLevel)
+	config.EnsureWorkspace(config.GetConfigPath(), project.Workspace)
+	slog.Debug("Using project file", "project", path, "workspace", project.Workspace)
 }
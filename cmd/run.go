@@ -0,0 +1,56 @@
+/*
+Copyright © 2024 Zac Orndorff <zac@orndorff.dev>
+*/
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/SandwichLabs/duck-tape/workspace"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run <script.sql | saved query name>",
+	Short: "Run a SQL script or saved query",
+	Long: `Runs every statement in a SQL script in order and prints the result of the last one.
+	dt run script.sql
+	dt run script.sql --all-results
+
+	Scripts may start with a shebang line so they can be executed directly:
+	#!/usr/bin/env -S dt run
+	select 42;
+
+	When no file exists at the given path, the name is looked up in the workspace's saved queries
+	and run with the connections it was saved with. A query with parameters is saved without
+	running when no -p is given:
+	dt query "select * from pg.users where id = $id" -c pg --save user_by_id
+	dt run user_by_id -p 1
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, err := os.ReadFile(args[0])
+		if err == nil {
+			err = runScript(cmd, scriptSource{SQL: string(src), ReadStdin: true})
+			checkErr(err)
+			return
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			cobra.CheckErr(err)
+		}
+
+		query, err := workspace.WorkspaceQuery(viper.GetString("workspace"), args[0])
+		cobra.CheckErr(err)
+
+		err = runScript(cmd, scriptSource{SQL: query.SQL, Connections: query.Connections, ReadStdin: true})
+		checkErr(err)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	addQueryFlags(runCmd)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 Zac Orndorff <zac@orndorff.dev>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/output"
+	"github.com/SandwichLabs/duck-tape/workspace"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var savedCmd = &cobra.Command{
+	Use:   "saved",
+	Short: "Manage saved queries",
+	Long: `Saved queries are stored in the workspace config along with the connections they use.
+	Save one with: dt query "select ..." --save name
+	Run one with:  dt run name -p value`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		cobra.CheckErr(err)
+	},
+}
+
+var savedListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the saved queries in the workspace",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		workspaceStr := viper.GetString("workspace")
+		format, _ := cmd.Flags().GetString("format")
+
+		formatter, err := output.New(format, cmd.OutOrStdout(), output.Options{Header: true})
+		cobra.CheckErr(err)
+
+		names, err := workspace.ListWorkspaceQueries(workspaceStr)
+		cobra.CheckErr(err)
+
+		err = formatter.WriteHeader([]output.Column{
+			{Name: "name", Type: "VARCHAR"},
+			{Name: "connections", Type: "VARCHAR[]"},
+			{Name: "params", Type: "VARCHAR[]"},
+		})
+		cobra.CheckErr(err)
+
+		for _, name := range names {
+			query, err := workspace.WorkspaceQuery(workspaceStr, name)
+			cobra.CheckErr(err)
+			err = formatter.WriteRow([]interface{}{query.Name, stringsToValues(query.Connections), stringsToValues(query.Params)})
+			cobra.CheckErr(err)
+		}
+
+		err = formatter.Flush()
+		cobra.CheckErr(err)
+	},
+}
+
+var savedShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Print a saved query as a runnable SQL script",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		query, err := workspace.WorkspaceQuery(viper.GetString("workspace"), args[0])
+		cobra.CheckErr(err)
+
+		fmt.Fprint(cmd.OutOrStdout(), query.Script())
+	},
+}
+
+var savedRmCmd = &cobra.Command{
+	Use:     "rm <name>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a saved query",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, err := workspace.DeleteWorkspaceQuery(viper.GetString("workspace"), args[0], true)
+		cobra.CheckErr(err)
+		slog.Info("Removed saved query", "name", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(savedCmd)
+	savedCmd.AddCommand(savedListCmd)
+	savedCmd.AddCommand(savedShowCmd)
+	savedCmd.AddCommand(savedRmCmd)
+	savedListCmd.Flags().StringP("format", "F", "table", fmt.Sprintf("Output format (%s)", strings.Join(output.Names(), ", ")))
+}
+
+// stringsToValues converts a string slice into the []interface{} form list columns are encoded from.
+func stringsToValues(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2024 Zac Orndorff <zac@orndorff.dev>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/SandwichLabs/duck-tape/config"
+	"github.com/SandwichLabs/duck-tape/secret"
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var secretCmd = &cobra.Command{
+	Use:     "secret",
+	Aliases: []string{"secrets"},
+	Short:   "Manage the encrypted secret store",
+	Long: fmt.Sprintf(`Connection strings can reference secrets instead of embedding credentials:
+
+	${env:PG_PASSWORD}          an environment variable
+	${file:/run/secrets/pg}     the contents of a file
+	${cmd:pass show db/pg}      the output of a shell command
+	${keyring:pg}               the OS keyring entry for service "dt", account "pg"
+	${secret:pg}                a secret from the encrypted store managed by these commands
+
+	References are resolved when the connection is attached, so the config file can be shared.
+	The store is encrypted with a passphrase read from %s, and lives in
+	secrets.json next to config.yaml unless secrets_file is set.
+
+	dt secret set pg_password
+	dt set connection --name pg --type postgres --conn-string 'host=db user=app password=${secret:pg_password}'`, secret.PassphraseEnv),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		cobra.CheckErr(err)
+	},
+}
+
+var secretSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Store a secret, read from --value, piped stdin or a prompt",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		value, _ := cmd.Flags().GetString("value")
+		if !cmd.Flags().Changed("value") {
+			var err error
+			value, err = readSecretValue(cmd, args[0])
+			cobra.CheckErr(err)
+		}
+
+		err := secretStore().Set(args[0], value)
+		cobra.CheckErr(err)
+		slog.Info("Stored secret", "name", args[0])
+	},
+}
+
+var secretListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the names of the stored secrets",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		names, err := secretStore().Names()
+		cobra.CheckErr(err)
+		for _, name := range names {
+			fmt.Fprintln(cmd.OutOrStdout(), name)
+		}
+	},
+}
+
+var secretRmCmd = &cobra.Command{
+	Use:     "rm <name>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a secret from the store",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := secretStore().Delete(args[0])
+		cobra.CheckErr(err)
+		slog.Info("Removed secret", "name", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(secretCmd)
+	secretCmd.AddCommand(secretSetCmd)
+	secretCmd.AddCommand(secretListCmd)
+	secretCmd.AddCommand(secretRmCmd)
+
+	secretSetCmd.Flags().String("value", "", "The secret value (avoid this in shared shells, it ends up in history)")
+
+	// The store's path depends on the config, so it's looked up when a reference is resolved
+	secret.Register("secret", secret.ProviderFunc(func(name string) (string, error) {
+		return secretStore().Resolve(name)
+	}))
+}
+
+var (
+	sharedStoreMu sync.Mutex
+	sharedStore   *secret.Store
+)
+
+// secretStore opens the workspace-independent encrypted store. The store is shared by the
+// whole command, so its key is derived once however many references are resolved.
+func secretStore() *secret.Store {
+	path := viper.GetString("secrets_file")
+	if path == "" {
+		path = filepath.Join(config.GetConfigPath(), "secrets.json")
+	}
+
+	sharedStoreMu.Lock()
+	defer sharedStoreMu.Unlock()
+	if sharedStore == nil || sharedStore.Path != path {
+		sharedStore = secret.NewStore(path)
+	}
+	return sharedStore
+}
+
+func readSecretValue(cmd *cobra.Command, name string) (string, error) {
+	if StdinIsPiped() {
+		data, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var value string
+	err := huh.NewInput().
+		Title(fmt.Sprintf("Value for %s", name)).
+		EchoMode(huh.EchoModePassword).
+		Value(&value).
+		Run()
+	return value, err
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/output"
+)
+
+// StdinTable describes piped stdin data exposed to queries as a temporary view.
+type StdinTable struct {
+	Name   string
+	Format string
+	// Path is the temp file stdin was spooled to, so every pooled connection can read it.
+	Path string
+}
+
+var stdinFormats = map[string]string{
+	"csv":     "read_csv_auto(%s)",
+	"tsv":     "read_csv_auto(%s, delim = '\\t')",
+	"ndjson":  "read_json_auto(%s, format = 'newline_delimited')",
+	"json":    "read_json_auto(%s, format = 'array')",
+	"parquet": "read_parquet(%s)",
+}
+
+// StdinIsPiped reports whether stdin is a pipe or file rather than a terminal.
+func StdinIsPiped() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice == 0
+}
+
+// ReferencesRelation reports whether query mentions name as a whole word,
+// so stdin is only consumed when a query actually reads from it.
+func ReferencesRelation(query string, name string) bool {
+	re := regexp.MustCompile(`(?i)(^|[^\w.])"?` + regexp.QuoteMeta(name) + `"?($|[^\w])`)
+	return re.MatchString(query)
+}
+
+// sniffStdinFormat guesses the format of piped data from its first bytes.
+func sniffStdinFormat(head []byte) string {
+	if bytes.HasPrefix(head, []byte("PAR1")) {
+		return "parquet"
+	}
+	trimmed := bytes.TrimLeft(head, " \t\r\n\ufeff")
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return "json"
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return "ndjson"
+	}
+	firstLine, _, _ := bytes.Cut(trimmed, []byte("\n"))
+	if bytes.Count(firstLine, []byte("\t")) > bytes.Count(firstLine, []byte(",")) {
+		return "tsv"
+	}
+	return "csv"
+}
+
+// spoolStdin copies r to a temp file and resolves the "auto" format by sniffing the data.
+func spoolStdin(name string, format string, r io.Reader) (StdinTable, error) {
+	format = strings.ToLower(format)
+	if _, ok := stdinFormats[format]; !ok && format != "auto" && format != "" {
+		return StdinTable{}, fmt.Errorf("unknown stdin format %q (expected auto, csv, tsv, ndjson, json or parquet)", format)
+	}
+
+	buffered := bufio.NewReaderSize(r, 4096)
+	head, err := buffered.Peek(4096)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return StdinTable{}, fmt.Errorf("failed to read stdin: %w", err)
+	}
+	if format == "auto" || format == "" {
+		format = sniffStdinFormat(head)
+		slog.Debug("Sniffed stdin format", "format", format)
+	}
+
+	file, err := os.CreateTemp("", "dt-stdin-*")
+	if err != nil {
+		return StdinTable{}, fmt.Errorf("failed to spool stdin: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, buffered); err != nil {
+		os.Remove(file.Name())
+		return StdinTable{}, fmt.Errorf("failed to spool stdin: %w", err)
+	}
+
+	return StdinTable{Name: name, Format: format, Path: file.Name()}, nil
+}
+
+// bootQuery creates the temporary view over the spooled stdin data.
+func (s StdinTable) bootQuery() string {
+	reader := fmt.Sprintf(stdinFormats[s.Format], output.QuoteLiteral(s.Path))
+	return fmt.Sprintf("CREATE OR REPLACE TEMP VIEW %s AS SELECT * FROM %s", output.QuoteIdentifier(s.Name), reader)
+}
@@ -4,21 +4,333 @@ Copyright © Zac Orndorff zac@orndorff.dev
 package cmd
 
 import (
-	"github.com/spf13/cobra"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/config"
+	"github.com/SandwichLabs/duck-tape/daemon"
+	"github.com/SandwichLabs/duck-tape/output"
+	"github.com/SandwichLabs/duck-tape/workspace"
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // workspaceCmd represents the get command
 var workspaceCmd = &cobra.Command{
-	Use:   "workspace",
-	Short: "Working with workspaces",
-	Long: `Workspaces are a way to organize your data and queries within DuckTape. 
-		A duckdb database is created for each workspace and all connections, data, queries are executed within a given 'workspace db'.`,
+	Use:     "workspace",
+	Aliases: []string{"workspaces", "ws"},
+	Short:   "Working with workspaces",
+	Long: `Workspaces are a way to organize your data and queries within DuckTape.
+		A duckdb database is created for each workspace and all connections, data, queries are executed within a given 'workspace db'.
+		dt workspace create staging
+		dt workspace use staging
+		dt workspace list`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		cobra.CheckErr(err)
+	},
+}
+
+var workspaceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List workspaces with their database size and number of connections",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		formatter, err := output.New(format, cmd.OutOrStdout(), output.Options{Header: true})
+		cobra.CheckErr(err)
+
+		err = formatter.WriteHeader([]output.Column{
+			{Name: "name", Type: "VARCHAR"},
+			{Name: "current", Type: "BOOLEAN"},
+			{Name: "database", Type: "VARCHAR"},
+			{Name: "db_size", Type: "BIGINT"},
+			{Name: "connections", Type: "INTEGER"},
+			{Name: "queries", Type: "INTEGER"},
+		})
+		cobra.CheckErr(err)
+
+		current := viper.GetString("workspace")
+		for _, name := range allWorkspaces() {
+			dbPath := workspaceDatabasePath(name, viper.GetString(fmt.Sprintf("%s.dbLocation", name)))
+			var dbSize interface{}
+			if info, err := os.Stat(dbPath); err == nil {
+				dbSize = info.Size()
+			}
+			connections, err := workspace.ListWorkspaceConnections(name)
+			cobra.CheckErr(err)
+			queries, err := workspace.ListWorkspaceQueries(name)
+			cobra.CheckErr(err)
+
+			err = formatter.WriteRow([]interface{}{name, name == current, dbPath, dbSize, len(connections), len(queries)})
+			cobra.CheckErr(err)
+		}
+		err = formatter.Flush()
+		cobra.CheckErr(err)
+	},
+}
+
+var workspaceCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a workspace",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		cobra.CheckErr(workspace.ValidateName(name))
+		if workspaceExists(name) {
+			cobra.CheckErr(fmt.Errorf("workspace %q already exists", name))
+		}
+
+		config.EnsureWorkspace(config.GetConfigPath(), name)
+		dbPath, _ := cmd.Flags().GetString("db")
+		if dbPath == "" {
+			dbPath = filepath.Join(config.WorkspacePath(name), "dt.db")
+		}
+		_, err := workspace.SetWorkspaceDb(name, dbPath, true)
+		cobra.CheckErr(err)
+		slog.Info("Created workspace", "name", name, "db", dbPath)
+	},
+}
+
+var workspaceUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Set the workspace commands use when -w isn't passed",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !workspaceExists(args[0]) {
+			cobra.CheckErr(fmt.Errorf("workspace %q not found, create it with dt workspace create %s", args[0], args[0]))
+		}
+		_, err := workspace.SetDefaultWorkspace(args[0], true)
+		cobra.CheckErr(err)
+		slog.Info("Switched workspace", "name", args[0])
+	},
+}
+
+var workspaceCurrentCmd = &cobra.Command{
+	Use:   "current",
+	Short: "Print the workspace commands use",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Fprintln(cmd.OutOrStdout(), viper.GetString("workspace"))
+	},
+}
+
+var workspaceRmCmd = &cobra.Command{
+	Use:     "rm <name>",
+	Aliases: []string{"remove"},
+	Short:   "Delete a workspace with its connections, saved queries and database",
+	Long: `Deletes a workspace's settings and its folder, including the workspace database.
+	A database kept outside the workspace folder (dt workspace create --db) is left in place.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		slog.Info("get called")
+		name := args[0]
+		cobra.CheckErr(checkWorkspaceIdle(name))
+
+		yes, _ := cmd.Flags().GetBool("yes")
+		if !yes {
+			confirmed, err := confirm(fmt.Sprintf("Delete workspace %s and its database?", name))
+			cobra.CheckErr(err)
+			if !confirmed {
+				return
+			}
+		}
+
+		dbPath := workspaceDatabasePath(name, viper.GetString(fmt.Sprintf("%s.dbLocation", name)))
+		if _, err := workspace.DeleteWorkspace(name, true); err != nil && !workspaceFolderExists(name) {
+			cobra.CheckErr(err)
+		}
+		err := os.RemoveAll(config.WorkspacePath(name))
+		cobra.CheckErr(err)
+
+		if !insideWorkspace(name, dbPath) {
+			slog.Info("Kept database outside the workspace folder", "db", dbPath)
+		}
+		slog.Info("Removed workspace", "name", name)
+	},
+}
+
+var workspaceCloneCmd = &cobra.Command{
+	Use:   "clone <source> <name>",
+	Short: "Create a workspace with a copy of another's connections and saved queries",
+	Long: `Copies a workspace's connections, default connections and saved queries to a new
+	workspace. The new workspace gets its own empty database unless --with-db copies it.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		source, name := args[0], args[1]
+		cobra.CheckErr(workspace.ValidateName(name))
+		if workspaceExists(name) {
+			cobra.CheckErr(fmt.Errorf("workspace %q already exists", name))
+		}
+
+		withDb, _ := cmd.Flags().GetBool("with-db")
+		if withDb {
+			// The database could change while it's copied
+			cobra.CheckErr(checkNoDaemon(source))
+		}
+		sourceDb := workspaceDatabasePath(source, viper.GetString(fmt.Sprintf("%s.dbLocation", source)))
+
+		_, err := workspace.CopyWorkspace(source, name, false)
+		cobra.CheckErr(err)
+		err = os.MkdirAll(config.WorkspacePath(name), 0755)
+		cobra.CheckErr(err)
+
+		// Never share a database file, DuckDB only lets one process write to it
+		dbPath := filepath.Join(config.WorkspacePath(name), "dt.db")
+		if withDb {
+			err = copyDatabase(sourceDb, dbPath)
+			cobra.CheckErr(err)
+		}
+		_, err = workspace.SetWorkspaceDb(name, dbPath, true)
+		cobra.CheckErr(err)
+		slog.Info("Cloned workspace", "from", source, "name", name, "db", dbPath)
+	},
+}
+
+var workspaceRenameCmd = &cobra.Command{
+	Use:   "rename <old name> <new name>",
+	Short: "Rename a workspace",
+	Long: `Renames a workspace and its folder. WORKSPACE connections in other workspaces
+	that attach it by its old name need to be updated.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		oldName, newName := args[0], args[1]
+		cobra.CheckErr(workspace.ValidateName(newName))
+		cobra.CheckErr(checkWorkspaceIdle(oldName))
+		if workspaceExists(newName) {
+			cobra.CheckErr(fmt.Errorf("workspace %q already exists", newName))
+		}
+
+		dbPath := workspaceDatabasePath(oldName, viper.GetString(fmt.Sprintf("%s.dbLocation", oldName)))
+		_, err := workspace.RenameWorkspace(oldName, newName, false)
+		cobra.CheckErr(err)
+
+		if workspaceFolderExists(oldName) {
+			err = os.Rename(config.WorkspacePath(oldName), config.WorkspacePath(newName))
+			cobra.CheckErr(err)
+		}
+		if insideWorkspace(oldName, dbPath) {
+			dbPath = filepath.Join(config.WorkspacePath(newName), filepath.Base(dbPath))
+		}
+		_, err = workspace.SetWorkspaceDb(newName, dbPath, true)
+		cobra.CheckErr(err)
+		slog.Info("Renamed workspace", "from", oldName, "to", newName)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(workspaceCmd)
+	workspaceCmd.AddCommand(workspaceListCmd)
+	workspaceCmd.AddCommand(workspaceCreateCmd)
+	workspaceCmd.AddCommand(workspaceUseCmd)
+	workspaceCmd.AddCommand(workspaceCurrentCmd)
+	workspaceCmd.AddCommand(workspaceRmCmd)
+	workspaceCmd.AddCommand(workspaceCloneCmd)
+	workspaceCmd.AddCommand(workspaceRenameCmd)
+
+	workspaceListCmd.Flags().StringP("format", "F", "table", fmt.Sprintf("Output format (%s)", strings.Join(output.Names(), ", ")))
+	workspaceCreateCmd.Flags().String("db", "", "Database file for the workspace (default is dt.db in the workspace folder)")
+	workspaceRmCmd.Flags().BoolP("yes", "y", false, "Delete without asking for confirmation")
+	workspaceCloneCmd.Flags().Bool("with-db", false, "Copy the source workspace's database as well")
+}
+
+// allWorkspaces returns the workspaces in the config along with any workspace folders
+// that have no settings yet, sorted.
+func allWorkspaces() []string {
+	names := workspace.ListWorkspaces()
+	entries, _ := os.ReadDir(config.GetConfigPath())
+	for _, entry := range entries {
+		if entry.IsDir() && workspace.ValidateName(entry.Name()) == nil && !slices.Contains(names, entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func workspaceExists(name string) bool {
+	if _, err := workspace.WorkspaceSettings(name); err == nil {
+		return true
+	}
+	return workspaceFolderExists(name)
+}
+
+func workspaceFolderExists(name string) bool {
+	info, err := os.Stat(config.WorkspacePath(name))
+	return err == nil && info.IsDir()
+}
+
+// insideWorkspace reports whether path is in the workspace's folder.
+func insideWorkspace(name string, path string) bool {
+	return filepath.Dir(filepath.Clean(path)) == filepath.Clean(config.WorkspacePath(name))
+}
+
+// checkWorkspaceIdle refuses to change a workspace that this command or a daemon is using.
+func checkWorkspaceIdle(name string) error {
+	if !workspaceExists(name) {
+		return fmt.Errorf("workspace %q not found", name)
+	}
+	if name == viper.GetString("workspace") {
+		return fmt.Errorf("workspace %q is in use, switch to another with dt workspace use or -w first", name)
+	}
+	return checkNoDaemon(name)
+}
+
+func checkNoDaemon(name string) error {
+	if daemon.Running(daemon.SocketPath(config.WorkspacePath(name))) {
+		return fmt.Errorf("a daemon is running for workspace %q, stop it with dt daemon stop -w %s first", name, name)
+	}
+	return nil
+}
+
+// copyDatabase copies a DuckDB file along with its write-ahead log, if there is one.
+func copyDatabase(from string, to string) error {
+	for _, suffix := range []string{"", ".wal"} {
+		err := copyFile(from+suffix, to+suffix)
+		if suffix != "" && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("copying database: %w", err)
+		}
+	}
+	return nil
+}
+
+func copyFile(from string, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// confirm asks a yes/no question, refusing to guess when there's no terminal to ask on.
+func confirm(question string) (bool, error) {
+	if StdinIsPiped() {
+		return false, errors.New("refusing to continue without confirmation, pass --yes")
+	}
+	var confirmed bool
+	if err := huh.NewConfirm().Title(question).Value(&confirmed).Run(); err != nil {
+		return false, fmt.Errorf("asking for confirmation: %w, pass --yes", err)
+	}
+	return confirmed, nil
 }
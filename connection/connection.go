@@ -4,10 +4,13 @@ Copyright © 2024 Zac Orndorff zac@orndorff.dev
 package connection
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 
-	"github.com/charmbracelet/huh"
-	"github.com/spf13/cobra"
+	"github.com/SandwichLabs/duck-tape/redact"
+	"github.com/SandwichLabs/duck-tape/secret"
 	"github.com/spf13/viper"
 )
 
@@ -18,8 +21,77 @@ type ConnectionConfig struct {
 	EnableWrite bool   `yaml:"enable_write"` // Optional field to enable write operations
 }
 
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateName checks a connection name. The name is used both as a config key
+// and as the catalog alias in ATTACH, so it must be a plain identifier.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid connection name %q: use letters, digits and underscores", name)
+	}
+	return nil
+}
+
+// checkIdentifiers checks the name and type, which boot SQL uses as identifiers. Only
+// connections saved from the command line go through Validate, so those loaded from a config
+// or project file are checked here before anything runs.
+func (c ConnectionConfig) checkIdentifiers() error {
+	if err := ValidateName(c.Name); err != nil {
+		return err
+	}
+	if !namePattern.MatchString(c.Type) {
+		return fmt.Errorf("invalid connection type %q", c.Type)
+	}
+	return nil
+}
+
+// checkSaved checks a connection loaded from a config file before it's booted: its
+// identifiers, and the type's own checks, which refuse e.g. an HTTPSFS connection string
+// that is neither options nor a URL.
+func (c ConnectionConfig) checkSaved() error {
+	if err := c.checkIdentifiers(); err != nil {
+		return err
+	}
+	if t, ok := LookupType(c.Type); ok {
+		return t.Validate(c)
+	}
+	return nil
+}
+
+// Validate checks that a connection can be saved and attached.
+func (c ConnectionConfig) Validate() error {
+	if err := ValidateName(c.Name); err != nil {
+		return err
+	}
+	t, ok := LookupType(c.Type)
+	if !ok {
+		return fmt.Errorf("unknown connection type %q (expected one of %s)", c.Type, strings.Join(TypeNames(), ", "))
+	}
+	if c.ConnString == "" && t.Kind() != KindSecret {
+		return errors.New("a connection string is required")
+	}
+	if c.EnableWrite && !t.Capabilities().Write {
+		return fmt.Errorf("%s connections are read-only", t.Name())
+	}
+	return t.Validate(c)
+}
+
+// Redacted returns a copy of the connection with credentials hidden from the connection string.
+func (c ConnectionConfig) Redacted() ConnectionConfig {
+	c.ConnString = c.redact(c.ConnString)
+	return c
+}
+
+// redact hides credentials in s using the connection type's redaction.
+func (c ConnectionConfig) redact(s string) string {
+	if t, ok := LookupType(c.Type); ok {
+		return t.Redact(s)
+	}
+	return RedactConnString(s)
+}
+
 func (c ConnectionConfig) String() string {
-	return fmt.Sprintf("ConnectionConfig{ConnString: %s, Name: %s, Type: %s}", c.ConnString, c.Name, c.Type)
+	return fmt.Sprintf("ConnectionConfig{ConnString: %s, Name: %s, Type: %s}", c.redact(c.ConnString), c.Name, c.Type)
 }
 
 func (c ConnectionConfig) ReadWriteMode() string {
@@ -29,6 +101,52 @@ func (c ConnectionConfig) ReadWriteMode() string {
 	return ", READ_ONLY"
 }
 
+// Resolve returns a copy of the connection with the secret references in its connection
+// string, such as ${env:PG_PASSWORD}, replaced by their values.
+func (c ConnectionConfig) Resolve() (ConnectionConfig, error) {
+	// A URL saved as an HTTPSFS connection isn't options, so it's expanded whole
+	if t, ok := LookupType(c.Type); ok && t.Format() == FormatOptions && !legacyURL(c.ConnString) {
+		return c.resolveOptions()
+	}
+	credentials := redact.CredentialReferences(c.ConnString)
+	connString, err := secret.ExpandHiding(c.ConnString, func(reference string) bool {
+		return credentials[reference]
+	})
+	if err != nil {
+		return c, fmt.Errorf("connection %s: %w", c.Name, err)
+	}
+	c.ConnString = connString
+	return c, nil
+}
+
+// Extensions returns the DuckDB extensions that must be installed and loaded before attaching.
+func (c ConnectionConfig) Extensions() []string {
+	if t, ok := LookupType(c.Type); ok {
+		return t.Extensions(c)
+	}
+	return []string{c.Type}
+}
+
+// BootStatements returns the statements that make the connection available to queries,
+// such as ATTACH for databases and CREATE SECRET for storage connections.
+func (c ConnectionConfig) BootStatements() []string {
+	if t, ok := LookupType(c.Type); ok {
+		return t.BootStatements(c)
+	}
+	return []string{c.AttachStatement()}
+}
+
+// AttachStatement returns the ATTACH statement that mounts the connection under its name
+// using the extension named by its type.
+func (c ConnectionConfig) AttachStatement() string {
+	return fmt.Sprintf("ATTACH IF NOT EXISTS %s as %s (TYPE %s %s);", quoteLiteral(c.ConnString), c.Name, c.Type, c.ReadWriteMode())
+}
+
+// quoteLiteral quotes s as a SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
 func ConnectionFromViper(v *viper.Viper) ConnectionConfig {
 	return ConnectionConfig{
 		ConnString:  v.GetString("conn_string"),
@@ -37,45 +155,3 @@ func ConnectionFromViper(v *viper.Viper) ConnectionConfig {
 		EnableWrite: v.GetBool("enable_write"), // Read the optional field from viper
 	}
 }
-
-func ConnectionConfigForm() ConnectionConfig {
-	var (
-		name        string
-		conn        string
-		connType    string
-		enableWrite bool
-	)
-	// Set default values for the form fields
-	enableWrite = false
-
-	form := huh.NewForm(
-		huh.NewGroup(
-			huh.NewInput().
-				Title("Connection Name").
-				Value(&name),
-			huh.NewSelect[string]().
-				Title("Connection Type.").
-				Options(
-					huh.NewOption("PostgreSql", "POSTGRES"),
-					huh.NewOption("Http(s3, http)", "HTTPSFS"),
-					huh.NewOption("MySql", "MYSQL"),
-					huh.NewOption("SQLite", "SQLITE"),
-				).
-				Value(&connType),
-			huh.NewInput().
-				Title("Connection String").
-				Value(&conn),
-			huh.NewConfirm().
-				Title("Enable writes?").
-				Value(&enableWrite),
-		))
-
-	err := form.Run()
-	cobra.CheckErr(err)
-
-	return ConnectionConfig{
-		Name:       name,
-		ConnString: conn,
-		Type:       connType,
-	}
-}
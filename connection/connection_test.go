@@ -0,0 +1,186 @@
+package connection_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactConnString(t *testing.T) {
+	assert.Equal(t, "host=db password=REDACTED dbname=app", connection.RedactConnString("host=db password=hunter2 dbname=app"))
+	assert.Equal(t, "postgres://app:REDACTED@db:5432/app", connection.RedactConnString("postgres://app:hunter2@db:5432/app"))
+	assert.Equal(t, "/data/app.sqlite", connection.RedactConnString("/data/app.sqlite"))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, connection.ConnectionConfig{Name: "pg", Type: "POSTGRES", ConnString: "host=db"}.Validate())
+	assert.Error(t, connection.ConnectionConfig{Name: "my.pg", Type: "POSTGRES", ConnString: "host=db"}.Validate())
+	assert.Error(t, connection.ConnectionConfig{Name: "pg", Type: "ORACLE", ConnString: "host=db"}.Validate())
+	assert.Error(t, connection.ConnectionConfig{Name: "pg", Type: "POSTGRES"}.Validate())
+}
+
+func TestAttachStatement(t *testing.T) {
+	conn := connection.ConnectionConfig{Name: "pg", Type: "POSTGRES", ConnString: "host=db", EnableWrite: true}
+	assert.Equal(t, []string{"postgres"}, conn.Extensions())
+	assert.Equal(t, "ATTACH IF NOT EXISTS 'host=db' as pg (TYPE POSTGRES );", conn.AttachStatement())
+}
+
+func TestBootErrors(t *testing.T) {
+	conn := connection.ConnectionConfig{Name: "pg", Type: "POSTGRES", ConnString: "host=db password=hunter2"}
+
+	err := conn.Boot(func(query string) error {
+		if strings.HasPrefix(query, "INSTALL") {
+			return errors.New("Failed to download extension")
+		}
+		return nil
+	})
+	var extensionErr *connection.ExtensionError
+	assert.ErrorAs(t, err, &extensionErr)
+	assert.Equal(t, "install", extensionErr.Op)
+	assert.Equal(t, "pg", extensionErr.Connection)
+
+	err = conn.Boot(func(query string) error {
+		if strings.HasPrefix(query, "ATTACH") {
+			return fmt.Errorf(`Unable to connect to Postgres at "%s": FATAL: password authentication failed for user "app"`, conn.ConnString)
+		}
+		return nil
+	})
+	var authErr *connection.AuthError
+	assert.ErrorAs(t, err, &authErr)
+	assert.NotContains(t, err.Error(), "hunter2")
+
+	err = conn.Boot(func(query string) error {
+		if strings.HasPrefix(query, "ATTACH") {
+			return errors.New("could not translate host name")
+		}
+		return nil
+	})
+	var attachErr *connection.AttachError
+	assert.ErrorAs(t, err, &attachErr)
+	assert.Contains(t, err.Error(), "connection pg")
+
+	// Names from a config file never went through Validate, so Boot refuses them before running SQL
+	for _, bad := range []connection.ConnectionConfig{
+		{Name: "pg; DROP TABLE t", Type: "POSTGRES", ConnString: "host=db"},
+		{Name: "pg", Type: "POSTGRES); DROP TABLE t; --", ConnString: "host=db"},
+	} {
+		ran := false
+		err = bad.Boot(func(query string) error {
+			ran = true
+			return nil
+		})
+		assert.ErrorAs(t, err, &attachErr)
+		assert.False(t, ran)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("DT_TEST_PG_PASSWORD", "hunter2")
+	conn := connection.ConnectionConfig{Name: "pg", Type: "POSTGRES", ConnString: "host=db password=${env:DT_TEST_PG_PASSWORD}"}
+
+	// References stay visible, they aren't secret themselves
+	assert.Equal(t, conn.ConnString, conn.Redacted().ConnString)
+
+	resolved, err := conn.Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, "host=db password=hunter2", resolved.ConnString)
+	assert.Equal(t, "dsn host=db password=REDACTED", connection.RedactConnString("dsn host=db password=hunter2"))
+	assert.Equal(t, "REDACTED", connection.RedactConnString("hunter2"))
+}
+
+func TestStorageConnections(t *testing.T) {
+	t.Setenv("DT_TEST_S3_SECRET", "it's secret")
+	conn := connection.ConnectionConfig{
+		Name:       "lake",
+		Type:       "S3",
+		ConnString: "key_id=minio secret=${env:DT_TEST_S3_SECRET} endpoint=localhost:9000 url_style=path use_ssl=false scope=s3://bucket",
+	}
+	assert.NoError(t, conn.Validate())
+	assert.True(t, conn.IsStorage())
+	assert.Equal(t, []string{"httpfs"}, conn.Extensions())
+
+	resolved, err := conn.Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"CREATE OR REPLACE SECRET lake (TYPE S3, ENDPOINT 'localhost:9000', KEY_ID 'minio', SCOPE 's3://bucket', SECRET 'it''s secret', URL_STYLE 'path', USE_SSL false);",
+		resolved.BootStatements()[0])
+	// The resolved secret is hidden wherever it appears, the other options aren't
+	assert.Equal(t, "REDACTED at localhost:9000", connection.RedactConnString("it's secret at localhost:9000"))
+
+	legacy := connection.ConnectionConfig{Name: "old", Type: "HTTPSFS", ConnString: "s3_region=us-east-1"}
+	assert.Equal(t, "CREATE OR REPLACE SECRET old (TYPE S3, REGION 'us-east-1');", legacy.BootStatements()[0])
+
+	// Before the storage types, HTTPSFS connections attached their connection string as a URL
+	remote := connection.ConnectionConfig{Name: "remote", Type: "HTTPSFS", ConnString: "https://example.com/db.duckdb?v=1"}
+	assert.NoError(t, remote.Validate())
+	resolved, err = remote.Resolve()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ATTACH IF NOT EXISTS 'https://example.com/db.duckdb?v=1' as remote (READ_ONLY);"}, resolved.BootStatements())
+	remote.EnableWrite = true
+	assert.ErrorContains(t, remote.Validate(), "read-only")
+
+	unknown := connection.ConnectionConfig{Name: "old", Type: "HTTPSFS", ConnString: "us-east-1"}
+	assert.ErrorContains(t, unknown.Validate(), "save it again as an S3 connection")
+	ran := false
+	err = unknown.Boot(func(query string) error {
+		ran = true
+		return nil
+	})
+	var attachErr *connection.AttachError
+	assert.ErrorAs(t, err, &attachErr)
+	assert.False(t, ran)
+
+	azure := connection.ConnectionConfig{Name: "blobs", Type: "AZURE", ConnString: "provider=credential_chain account_name=acct"}
+	assert.Equal(t, []string{"azure"}, azure.Extensions())
+	assert.Equal(t, "CREATE OR REPLACE SECRET blobs (TYPE AZURE, ACCOUNT_NAME 'acct', PROVIDER 'credential_chain');", azure.BootStatements()[0])
+
+	pg := connection.ConnectionConfig{Name: "pg", Type: "POSTGRES", ConnString: "host=db"}
+	assert.False(t, pg.IsStorage())
+	assert.Equal(t, []string{pg.AttachStatement()}, pg.BootStatements())
+}
+
+func TestBootStatements(t *testing.T) {
+	duck := connection.ConnectionConfig{Name: "local", Type: "duckdb", ConnString: "/data/it's.duckdb"}
+	assert.Empty(t, duck.Extensions())
+	assert.Equal(t, []string{"ATTACH IF NOT EXISTS '/data/it''s.duckdb' as local (READ_ONLY);"}, duck.BootStatements())
+
+	duck.EnableWrite = true
+	assert.Equal(t, []string{"ATTACH IF NOT EXISTS '/data/it''s.duckdb' as local;"}, duck.BootStatements())
+
+	iceberg := connection.ConnectionConfig{Name: "orders", Type: "ICEBERG", ConnString: "s3://lake/orders"}
+	assert.Equal(t, []string{"iceberg"}, iceberg.Extensions())
+	assert.Equal(t, []string{"CREATE OR REPLACE TEMP VIEW orders AS SELECT * FROM iceberg_scan('s3://lake/orders', allow_moved_paths = true);"}, iceberg.BootStatements())
+
+	delta := connection.ConnectionConfig{Name: "events", Type: "DELTA", ConnString: "/lake/events"}
+	assert.Equal(t, []string{"CREATE OR REPLACE TEMP VIEW events AS SELECT * FROM delta_scan('/lake/events');"}, delta.BootStatements())
+
+	lake := connection.ConnectionConfig{Name: "lake", Type: "DUCKLAKE", ConnString: "metadata=metadata.ducklake data_path=s3://bucket/lake/"}
+	assert.NoError(t, lake.Validate())
+	assert.Equal(t, []string{"ducklake"}, lake.Extensions())
+	assert.Equal(t, []string{"ATTACH IF NOT EXISTS 'ducklake:metadata.ducklake' as lake (DATA_PATH 's3://bucket/lake/', READ_ONLY);"}, lake.BootStatements())
+
+	assert.Contains(t, connection.TypeNames(), "WORKSPACE")
+	assert.Error(t, connection.ConnectionConfig{Name: "x", Type: "ORACLE", ConnString: "x"}.Validate())
+}
+
+func TestTypeCapabilities(t *testing.T) {
+	iceberg := connection.ConnectionConfig{Name: "orders", Type: "ICEBERG", ConnString: "/lake/orders", EnableWrite: true}
+	assert.ErrorContains(t, iceberg.Validate(), "read-only")
+
+	ws := connection.ConnectionConfig{Name: "staging", Type: "WORKSPACE", ConnString: "../staging"}
+	assert.Error(t, ws.Validate())
+
+	for _, typ := range connection.RegisteredTypes() {
+		assert.NotEmpty(t, typ.Label(), typ.Name())
+		assert.NotEmpty(t, typ.Fields(), typ.Name())
+	}
+
+	pg, ok := connection.LookupType("postgres")
+	assert.True(t, ok)
+	assert.Equal(t, connection.KindCatalog, pg.Kind())
+	assert.True(t, pg.Capabilities().Write)
+}
@@ -0,0 +1,100 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Diagnostics reports how far a connection got when attached on its own.
+type Diagnostics struct {
+	Name string
+	Type string
+	// Extensions maps each required extension to "ok", "install failed" or "load failed".
+	Extensions map[string]string
+	// Attached reports whether the boot statements, such as ATTACH or CREATE SECRET, succeeded.
+	Attached bool
+	// Latency is the time taken by the boot statements, such as ATTACH or CREATE SECRET.
+	Latency time.Duration
+	Schemas int
+	// Writable reports whether the attached catalog accepts writes.
+	Writable bool
+	// Step names the stage that failed: config, install, load, attach or inspect.
+	Step string
+	Err  error
+}
+
+func (d Diagnostics) OK() bool {
+	return d.Err == nil
+}
+
+// Diagnose installs, loads and attaches a connection on db, which should be a
+// scratch database so that the check doesn't disturb the workspace.
+func Diagnose(ctx context.Context, db *sql.DB, c ConnectionConfig) Diagnostics {
+	d := Diagnostics{Name: c.Name, Type: c.Type, Extensions: map[string]string{}}
+	if err := c.checkSaved(); err != nil {
+		d.Step, d.Err = "config", err
+		return d
+	}
+
+	// Pin a single connection so the attachment is visible to the inspection queries
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		d.Step, d.Err = "attach", err
+		return d
+	}
+	defer conn.Close()
+
+	for _, extension := range c.Extensions() {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("INSTALL '%s'", extension)); err != nil {
+			d.Extensions[extension] = "install failed"
+			d.Step, d.Err = "install", &ExtensionError{Connection: c.Name, Extension: extension, Op: "install", Err: err}
+			return d
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("LOAD '%s'", extension)); err != nil {
+			d.Extensions[extension] = "load failed"
+			d.Step, d.Err = "load", &ExtensionError{Connection: c.Name, Extension: extension, Op: "load", Err: err}
+			return d
+		}
+		d.Extensions[extension] = "ok"
+	}
+
+	resolved, err := c.Resolve()
+	if err != nil {
+		d.Step, d.Err = "config", err
+		return d
+	}
+
+	start := time.Now()
+	for _, statement := range resolved.BootStatements() {
+		if _, err = conn.ExecContext(ctx, statement); err != nil {
+			d.Latency = time.Since(start)
+			d.Step, d.Err = "attach", resolved.attachError(err)
+			return d
+		}
+	}
+	d.Latency = time.Since(start)
+	d.Attached = true
+
+	// Only attached catalogs have schemas to inspect
+	if t, _ := LookupType(c.Type); t == nil || t.Kind() != KindCatalog {
+		return d
+	}
+
+	err = conn.QueryRowContext(ctx, "SELECT count(*) FROM duckdb_schemas() WHERE database_name = ?", c.Name).Scan(&d.Schemas)
+	if err != nil {
+		d.Step, d.Err = "inspect", err
+		return d
+	}
+
+	var readonly bool
+	err = conn.QueryRowContext(ctx, "SELECT readonly FROM duckdb_databases() WHERE database_name = ?", c.Name).Scan(&readonly)
+	if err != nil {
+		d.Step, d.Err = "inspect", err
+		return d
+	}
+	d.Writable = !readonly
+
+	return d
+}
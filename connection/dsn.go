@@ -0,0 +1,189 @@
+package connection
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Field is one input of a connection type's form, and one part of its connection string.
+type Field struct {
+	// Key is the DSN key the value is written under.
+	Key         string
+	Title       string
+	Description string
+	Default     string
+	// Options limits the value to a fixed set. The empty option means the extension's default.
+	Options  []string
+	Required bool
+	// Secret hides the value while it's typed.
+	Secret bool
+	// File shows a file picker.
+	File     bool
+	Validate func(string) error
+}
+
+func validatePort(value string) error {
+	if value == "" {
+		return nil
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", value)
+	}
+	return nil
+}
+
+// Fields returns the form fields for a connection type.
+func Fields(connType string) []Field {
+	if t, ok := LookupType(connType); ok {
+		return t.Fields()
+	}
+	return nil
+}
+
+// BuildConnString generates a connection string for the type from form values. Values
+// without a matching field are kept, so editing a connection doesn't lose options the
+// form doesn't know about.
+func BuildConnString(connType string, values map[string]string) (string, error) {
+	t, ok := LookupType(connType)
+	if !ok {
+		return "", fmt.Errorf("unknown connection type %q", connType)
+	}
+
+	fields := t.Fields()
+	for _, field := range fields {
+		if err := field.check(values[field.Key]); err != nil {
+			return "", err
+		}
+	}
+
+	if t.Format() == FormatPath {
+		return values[fields[0].Key], nil
+	}
+
+	known := map[string]bool{}
+	var pairs []string
+	for _, field := range fields {
+		known[field.Key] = true
+		if value := values[field.Key]; value != "" {
+			pairs = append(pairs, field.Key+"="+quoteDSNValue(value))
+		}
+	}
+
+	var extra []string
+	for key := range values {
+		if !known[key] && values[key] != "" {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		pairs = append(pairs, key+"="+quoteDSNValue(values[key]))
+	}
+	return strings.Join(pairs, " "), nil
+}
+
+// ParseConnString splits a connection string into form values. Postgres and MySQL URIs
+// are accepted as well as key=value strings.
+func ParseConnString(connType string, connString string) map[string]string {
+	t, ok := LookupType(connType)
+	if ok && t.Format() == FormatPath {
+		return map[string]string{t.Fields()[0].Key: connString}
+	}
+	if ok && t.Format() == FormatOptions {
+		return optionValues(connString)
+	}
+	if u, err := url.Parse(connString); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql" || u.Scheme == "mysql") {
+		return parseURI(strings.ToUpper(connType), u)
+	}
+	return parseKeyValues(connString)
+}
+
+func (f Field) check(value string) error {
+	if f.Required && value == "" {
+		return fmt.Errorf("%s is required", strings.ToLower(f.Title))
+	}
+	if len(f.Options) > 0 && value != "" {
+		found := false
+		for _, option := range f.Options {
+			found = found || option == value
+		}
+		if !found {
+			return fmt.Errorf("invalid %s %q (expected one of %s)", strings.ToLower(f.Title), value, strings.Join(f.Options[1:], ", "))
+		}
+	}
+	if f.Validate != nil {
+		return f.Validate(value)
+	}
+	return nil
+}
+
+// quoteDSNValue quotes a key=value DSN value in libpq style when it contains spaces or quotes.
+func quoteDSNValue(value string) string {
+	if !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// parseKeyValues reads a libpq style key=value string, undoing quoteDSNValue.
+func parseKeyValues(s string) map[string]string {
+	values := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " ")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, "'") {
+			i := 1
+			for ; i < len(s) && s[i] != '\''; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			s = s[min(i+1, len(s)):]
+		} else {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			value.WriteString(s[:end])
+			s = s[end:]
+		}
+		values[key] = value.String()
+	}
+	return values
+}
+
+func parseURI(connType string, u *url.URL) map[string]string {
+	databaseKey := "dbname"
+	if connType == "MYSQL" {
+		databaseKey = "database"
+	}
+
+	values := map[string]string{
+		"host":      u.Hostname(),
+		"port":      u.Port(),
+		databaseKey: strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		values["user"] = u.User.Username()
+		values["password"], _ = u.User.Password()
+	}
+	for key, vs := range u.Query() {
+		if len(vs) > 0 {
+			values[key] = vs[0]
+		}
+	}
+	return values
+}
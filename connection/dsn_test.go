@@ -0,0 +1,53 @@
+package connection_test
+
+import (
+	"testing"
+
+	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildConnString(t *testing.T) {
+	connString, err := connection.BuildConnString("postgres", map[string]string{
+		"host": "db", "port": "5432", "dbname": "app", "password": "it's secret", "application_name": "dt",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `host=db port=5432 dbname=app password='it\'s secret' application_name=dt`, connString)
+
+	connString, err = connection.BuildConnString("SQLITE", map[string]string{"path": "/data/app.sqlite"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/app.sqlite", connString)
+
+	_, err = connection.BuildConnString("POSTGRES", map[string]string{"host": "db"})
+	assert.ErrorContains(t, err, "database is required")
+
+	_, err = connection.BuildConnString("MYSQL", map[string]string{"host": "db", "database": "app", "port": "http"})
+	assert.ErrorContains(t, err, "invalid port")
+
+	_, err = connection.BuildConnString("POSTGRES", map[string]string{"host": "db", "dbname": "app", "sslmode": "always"})
+	assert.ErrorContains(t, err, "invalid ssl mode")
+}
+
+func TestParseConnString(t *testing.T) {
+	assert.Equal(t,
+		map[string]string{"host": "db", "dbname": "app", "password": `it's secret`},
+		connection.ParseConnString("POSTGRES", `host=db dbname=app password='it\'s secret'`))
+
+	assert.Equal(t,
+		map[string]string{"host": "db", "port": "5432", "dbname": "app", "user": "app", "password": "hunter2", "sslmode": "require"},
+		connection.ParseConnString("POSTGRES", "postgres://app:hunter2@db:5432/app?sslmode=require"))
+
+	assert.Equal(t,
+		map[string]string{"host": "db", "port": "", "database": "app", "user": "app", "password": ""},
+		connection.ParseConnString("MYSQL", "mysql://app@db/app"))
+
+	assert.Equal(t, map[string]string{"path": "/data/my app.sqlite"}, connection.ParseConnString("SQLITE", "/data/my app.sqlite"))
+
+	values := map[string]string{"region": "eu-west-1", "key_id": "abc", "secret": "${cmd:pass show s3}"}
+	connString, err := connection.BuildConnString("S3", values)
+	assert.NoError(t, err)
+	assert.Equal(t, values, connection.ParseConnString("S3", connString))
+
+	// HTTPSFS connections were saved with s3_ prefixed keys
+	assert.Equal(t, values, connection.ParseConnString("HTTPSFS", "s3_region=eu-west-1 s3_access_key_id=abc s3_secret_access_key='${cmd:pass show s3}'"))
+}
@@ -0,0 +1,102 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/redact"
+)
+
+// ExtensionError is returned when an extension a connection needs can't be installed or loaded.
+type ExtensionError struct {
+	Connection string
+	Extension  string
+	// Op is "install" or "load".
+	Op  string
+	Err error
+}
+
+func (e *ExtensionError) Error() string {
+	return redact.String(fmt.Sprintf("connection %s: failed to %s extension %s: %v", e.Connection, e.Op, e.Extension, e.Err))
+}
+
+func (e *ExtensionError) Unwrap() error {
+	return e.Err
+}
+
+// AttachError is returned when ATTACH fails for a reason other than authentication,
+// such as an unreachable host or a missing file.
+type AttachError struct {
+	Connection string
+	Err        error
+}
+
+func (e *AttachError) Error() string {
+	return redact.String(fmt.Sprintf("connection %s: failed to attach: %v", e.Connection, e.Err))
+}
+
+func (e *AttachError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned when the remote database rejects the connection's credentials.
+type AuthError struct {
+	Connection string
+	Err        error
+}
+
+func (e *AuthError) Error() string {
+	return redact.String(fmt.Sprintf("connection %s: authentication failed: %v", e.Connection, e.Err))
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// authFailurePattern matches the credential errors reported by the postgres and mysql scanners.
+var authFailurePattern = regexp.MustCompile(`(?i)password authentication failed|access denied for user|authentication failed|no password supplied|invalid password`)
+
+// attachError classifies an ATTACH failure as an AuthError or AttachError, with the
+// connection string redacted from the message since DuckDB may echo it back.
+func (c ConnectionConfig) attachError(err error) error {
+	msg := c.redact(err.Error())
+	if c.ConnString != "" {
+		msg = strings.ReplaceAll(msg, c.ConnString, c.redact(c.ConnString))
+	}
+	redactedErr := errors.New(msg)
+
+	if authFailurePattern.MatchString(msg) {
+		return &AuthError{Connection: c.Name, Err: redactedErr}
+	}
+	return &AttachError{Connection: c.Name, Err: redactedErr}
+}
+
+// Boot installs and loads the connection's extensions and attaches it, running each
+// statement with exec. Secret references are resolved just before the ATTACH or CREATE SECRET.
+// Failures are returned as an ExtensionError, AttachError or AuthError.
+func (c ConnectionConfig) Boot(exec func(query string) error) error {
+	if err := c.checkSaved(); err != nil {
+		return &AttachError{Connection: c.Name, Err: err}
+	}
+	for _, extension := range c.Extensions() {
+		if err := exec(fmt.Sprintf("INSTALL '%s'", extension)); err != nil {
+			return &ExtensionError{Connection: c.Name, Extension: extension, Op: "install", Err: err}
+		}
+		if err := exec(fmt.Sprintf("LOAD '%s'", extension)); err != nil {
+			return &ExtensionError{Connection: c.Name, Extension: extension, Op: "load", Err: err}
+		}
+	}
+
+	resolved, err := c.Resolve()
+	if err != nil {
+		return &AttachError{Connection: c.Name, Err: err}
+	}
+	for _, statement := range resolved.BootStatements() {
+		if err := exec(statement); err != nil {
+			return resolved.attachError(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package connection
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+)
+
+// typeOptions are the choices of the connection type select, in registration order.
+func typeOptions() []huh.Option[string] {
+	var options []huh.Option[string]
+	for _, t := range RegisteredTypes() {
+		if !t.Capabilities().Hidden {
+			options = append(options, huh.NewOption(t.Label(), t.Name()))
+		}
+	}
+	return options
+}
+
+// ConnectionConfigForm asks for a connection interactively. The first page picks the
+// name, type and write access, the second asks for the type's fields and builds the
+// connection string from them. Fields are pre-filled from existing, so passing a saved
+// connection edits it.
+func ConnectionConfigForm(existing ConnectionConfig) (ConnectionConfig, error) {
+	conn := existing
+	conn.Type = strings.ToUpper(conn.Type)
+	if conn.Type == "" {
+		conn.Type = "POSTGRES"
+	}
+
+	err := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Connection Name").
+				Description("Also the catalog alias used in queries, e.g. select * from name.table").
+				Validate(ValidateName).
+				Value(&conn.Name),
+			huh.NewSelect[string]().
+				Title("Connection Type").
+				Options(typeOptions()...).
+				Value(&conn.Type),
+			huh.NewConfirm().
+				Title("Enable writes?").
+				Value(&conn.EnableWrite),
+		),
+	).Run()
+	if err != nil {
+		return conn, err
+	}
+
+	values := map[string]string{}
+	if strings.EqualFold(existing.Type, conn.Type) && existing.ConnString != "" {
+		values = ParseConnString(conn.Type, existing.ConnString)
+	}
+
+	fields := Fields(conn.Type)
+	inputs := make([]string, len(fields))
+	formFields := make([]huh.Field, len(fields))
+	for i, field := range fields {
+		inputs[i] = values[field.Key]
+		if inputs[i] == "" {
+			inputs[i] = field.Default
+		}
+		formFields[i] = formField(field, &inputs[i])
+	}
+
+	err = huh.NewForm(huh.NewGroup(formFields...).Title(fmt.Sprintf("%s connection", conn.Type))).Run()
+	if err != nil {
+		return conn, err
+	}
+
+	for i, field := range fields {
+		values[field.Key] = inputs[i]
+	}
+	conn.ConnString, err = BuildConnString(conn.Type, values)
+	return conn, err
+}
+
+// formField builds the huh input for a field, validating it as it's filled in.
+func formField(field Field, value *string) huh.Field {
+	switch {
+	case len(field.Options) > 0:
+		options := make([]huh.Option[string], len(field.Options))
+		for i, option := range field.Options {
+			label := option
+			if option == "" {
+				label = "default"
+			}
+			options[i] = huh.NewOption(label, option)
+		}
+		return huh.NewSelect[string]().
+			Title(field.Title).
+			Description(field.Description).
+			Options(options...).
+			Value(value)
+	case field.File:
+		picker := huh.NewFilePicker().
+			Title(field.Title).
+			Description(field.Description).
+			Picking(true).
+			Validate(field.check).
+			Value(value)
+		if *value != "" {
+			picker = picker.CurrentDirectory(filepath.Dir(*value))
+		}
+		return picker
+	default:
+		input := huh.NewInput().
+			Title(field.Title).
+			Description(field.Description).
+			Validate(field.check).
+			Value(value)
+		if field.Secret {
+			input = input.EchoMode(huh.EchoModePassword)
+		}
+		return input
+	}
+}
@@ -0,0 +1,22 @@
+package connection
+
+import (
+	"log/slog"
+
+	"github.com/SandwichLabs/duck-tape/redact"
+)
+
+// RedactConnString hides the credentials in a connection string so it can be shown or logged.
+func RedactConnString(connString string) string {
+	return redact.String(connString)
+}
+
+// LogValue logs the connection with its credentials hidden.
+func (c ConnectionConfig) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("name", c.Name),
+		slog.String("type", c.Type),
+		slog.String("conn_string", c.redact(c.ConnString)),
+		slog.Bool("enable_write", c.EnableWrite),
+	)
+}
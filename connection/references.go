@@ -0,0 +1,59 @@
+package connection
+
+import "strings"
+
+// References are the names and paths a query refers to. They decide which of a
+// workspace's default connections have to be attached for it.
+type References struct {
+	// Names are the catalog, schema and table names the query reads or writes.
+	Names []string
+	// Paths are the URLs the query reads, such as s3://bucket/file.parquet.
+	Paths []string
+}
+
+// HasName reports whether name is referenced, ignoring case as DuckDB does.
+func (r References) HasName(name string) bool {
+	for _, n := range r.Names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPathPrefix reports whether any referenced path starts with one of the prefixes.
+func (r References) HasPathPrefix(prefixes ...string) bool {
+	for _, path := range r.Paths {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(strings.ToLower(path), strings.ToLower(prefix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Referencer is implemented by types that decide for themselves whether a query needs
+// the connection. Other types are needed when the query names the connection.
+type Referencer interface {
+	ReferencedBy(c ConnectionConfig, refs References) bool
+}
+
+// ReferencedBy reports whether a query with these references needs the connection.
+func (c ConnectionConfig) ReferencedBy(refs References) bool {
+	if t, ok := LookupType(c.Type); ok {
+		if r, ok := t.(Referencer); ok {
+			return r.ReferencedBy(c, refs)
+		}
+	}
+	return refs.HasName(c.Name)
+}
+
+// ReferencedBy matches paths under the secret's scope, or with one of the type's URL
+// schemes when it has no scope.
+func (t storageType) ReferencedBy(c ConnectionConfig, refs References) bool {
+	if scope := optionValues(c.ConnString)["scope"]; scope != "" {
+		return refs.HasPathPrefix(scope)
+	}
+	return refs.HasPathPrefix(t.schemes...)
+}
@@ -0,0 +1,109 @@
+package connection
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/redact"
+	"github.com/SandwichLabs/duck-tape/secret"
+)
+
+// legacyStorageKeys maps the keys HTTPSFS connections were saved with to CREATE SECRET options.
+var legacyStorageKeys = map[string]string{
+	"s3_region":            "region",
+	"s3_endpoint":          "endpoint",
+	"s3_access_key_id":     "key_id",
+	"s3_secret_access_key": "secret",
+	"s3_session_token":     "session_token",
+	"s3_url_style":         "url_style",
+	"s3_use_ssl":           "use_ssl",
+}
+
+// booleanStorageOptions are written to CREATE SECRET unquoted.
+var booleanStorageOptions = map[string]bool{"use_ssl": true}
+
+// IsStorage reports whether the connection is object storage credentials rather than
+// an attached database. Storage connections are created as DuckDB secrets, so queries
+// can read s3://, gcs://, r2:// and az:// paths directly.
+func (c ConnectionConfig) IsStorage() bool {
+	t, ok := LookupType(c.Type)
+	return ok && t.Kind() == KindSecret
+}
+
+func (c ConnectionConfig) secretType() string {
+	if t := strings.ToUpper(c.Type); t != "HTTPSFS" {
+		return t
+	}
+	return "S3"
+}
+
+// optionValues parses the connection string of a type that dt turns into statement
+// options, such as the CREATE SECRET options of a storage connection.
+func optionValues(connString string) map[string]string {
+	options := map[string]string{}
+	for key, value := range parseKeyValues(connString) {
+		key = strings.ToLower(key)
+		if option, ok := legacyStorageKeys[key]; ok {
+			key = option
+		}
+		options[key] = value
+	}
+	return options
+}
+
+// joinKeyValues writes options as a key=value string in key order.
+func joinKeyValues(options map[string]string) string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + quoteDSNValue(options[key])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// resolveOptions expands the secret references in each option separately, so that
+// resolved values containing spaces or quotes stay intact.
+func (c ConnectionConfig) resolveOptions() (ConnectionConfig, error) {
+	options := optionValues(c.ConnString)
+	for key, value := range options {
+		credential := redact.CredentialKey(key)
+		expanded, err := secret.ExpandHiding(value, func(string) bool { return credential })
+		if err != nil {
+			return c, fmt.Errorf("connection %s: %w", c.Name, err)
+		}
+		options[key] = expanded
+	}
+	c.ConnString = joinKeyValues(options)
+	return c, nil
+}
+
+// SecretStatement returns the CREATE SECRET statement for a storage connection, named
+// after the connection. The options in the connection string become the secret's options:
+//
+//	key_id=AKIA... secret=... region=eu-west-1 scope=s3://bucket
+//	CREATE OR REPLACE SECRET name (TYPE S3, KEY_ID 'AKIA...', REGION 'eu-west-1', SCOPE 's3://bucket', SECRET '...')
+func (c ConnectionConfig) SecretStatement() string {
+	options := optionValues(c.ConnString)
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{"TYPE " + c.secretType()}
+	for _, key := range keys {
+		value := options[key]
+		if booleanStorageOptions[key] {
+			parts = append(parts, fmt.Sprintf("%s %t", strings.ToUpper(key), strings.EqualFold(value, "true")))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s '%s'", strings.ToUpper(key), strings.ReplaceAll(value, "'", "''")))
+	}
+	return fmt.Sprintf("CREATE OR REPLACE SECRET %s (%s);", c.Name, strings.Join(parts, ", "))
+}
@@ -0,0 +1,382 @@
+package connection
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/SandwichLabs/duck-tape/redact"
+)
+
+// Kind is how a connection type is made available to queries.
+type Kind string
+
+const (
+	// KindCatalog types are attached as a catalog and queried as name.schema.table.
+	KindCatalog Kind = "catalog"
+	// KindSecret types register credentials used by paths such as s3://bucket/file.parquet.
+	KindSecret Kind = "secret"
+	// KindView types expose a single table as a view queried by the connection name.
+	KindView Kind = "view"
+)
+
+// Format is the shape of a type's connection string.
+type Format int
+
+const (
+	// FormatDSN connection strings are handed to the extension as is, e.g. a libpq DSN.
+	// The form builds them as key=value pairs.
+	FormatDSN Format = iota
+	// FormatPath connection strings are the value of the type's single field, e.g. a file path.
+	FormatPath
+	// FormatOptions connection strings are key=value options that the type turns into its
+	// boot statements. Secret references are resolved per value.
+	FormatOptions
+)
+
+// Capabilities are what a connection type supports.
+type Capabilities struct {
+	// Write means the connection can be attached read-write with enable_write.
+	Write bool
+	// Hidden types are accepted but not offered in the connection form.
+	Hidden bool
+}
+
+// Type is a kind of connection: how it's booted, validated, redacted and filled in.
+// Register a Type to make it available to every dt command; embed BaseType to get the
+// defaults and override what differs.
+type Type interface {
+	// Name is the upper case name saved as the connection's type.
+	Name() string
+	// Label is shown in the connection form.
+	Label() string
+	Kind() Kind
+	Capabilities() Capabilities
+	// Format is the shape of the connection string.
+	Format() Format
+	// Fields are the form inputs that build the connection string.
+	Fields() []Field
+	// Extensions are installed and loaded before the boot statements run.
+	Extensions(c ConnectionConfig) []string
+	// Validate checks type specific settings, after the name and connection string checks.
+	Validate(c ConnectionConfig) error
+	// BootStatements make a connection, with its secrets resolved, available to queries.
+	BootStatements(c ConnectionConfig) []string
+	// Redact hides the credentials in a connection string.
+	Redact(connString string) string
+}
+
+// BaseType implements Type from its fields. By default it attaches the connection with
+// ATTACH ... (TYPE name), using the extension of the same name.
+type BaseType struct {
+	TypeName  string
+	TypeLabel string
+	TypeKind  Kind
+	Caps      Capabilities
+	// ConnFormat is the shape of the connection string.
+	ConnFormat Format
+	FormFields []Field
+	// Requires lists the extensions to load. Nil means the extension named after the type.
+	Requires []string
+}
+
+func (b BaseType) Name() string               { return strings.ToUpper(b.TypeName) }
+func (b BaseType) Label() string              { return b.TypeLabel }
+func (b BaseType) Kind() Kind                 { return b.TypeKind }
+func (b BaseType) Capabilities() Capabilities { return b.Caps }
+func (b BaseType) Format() Format             { return b.ConnFormat }
+func (b BaseType) Fields() []Field            { return b.FormFields }
+
+func (b BaseType) Extensions(c ConnectionConfig) []string {
+	if b.Requires == nil {
+		return []string{strings.ToLower(b.TypeName)}
+	}
+	return b.Requires
+}
+
+func (b BaseType) Validate(c ConnectionConfig) error {
+	return nil
+}
+
+func (b BaseType) BootStatements(c ConnectionConfig) []string {
+	return []string{c.AttachStatement()}
+}
+
+func (b BaseType) Redact(connString string) string {
+	return redact.String(connString)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Type{}
+	typeOrder  []string
+)
+
+// Register adds a connection type, replacing any registered under the same name.
+func Register(t Type) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	name := strings.ToUpper(t.Name())
+	if _, ok := registry[name]; !ok {
+		typeOrder = append(typeOrder, name)
+	}
+	registry[name] = t
+}
+
+// LookupType returns the registered type with the given name, in any case.
+func LookupType(name string) (Type, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	t, ok := registry[strings.ToUpper(name)]
+	return t, ok
+}
+
+// RegisteredTypes returns the registered types in registration order.
+func RegisteredTypes() []Type {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]Type, len(typeOrder))
+	for i, name := range typeOrder {
+		types[i] = registry[name]
+	}
+	return types
+}
+
+// TypeNames returns the names of the registered types in registration order.
+func TypeNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return append([]string(nil), typeOrder...)
+}
+
+// WorkspaceDatabase returns the database file of a dt workspace, for WORKSPACE connections.
+// The cmd package sets it, as it knows where workspaces live and their dbLocation.
+var WorkspaceDatabase func(workspace string) string
+
+// attachFile attaches a DuckDB database file.
+func attachFile(name string, path string, enableWrite bool) string {
+	if enableWrite {
+		return fmt.Sprintf("ATTACH IF NOT EXISTS %s as %s;", quoteLiteral(path), name)
+	}
+	return fmt.Sprintf("ATTACH IF NOT EXISTS %s as %s (READ_ONLY);", quoteLiteral(path), name)
+}
+
+// duckdbType attaches a DuckDB database file, which needs no extension.
+type duckdbType struct{ BaseType }
+
+func (t duckdbType) BootStatements(c ConnectionConfig) []string {
+	return []string{attachFile(c.Name, c.ConnString, c.EnableWrite)}
+}
+
+// workspaceType attaches the database of another dt workspace.
+type workspaceType struct{ BaseType }
+
+func (t workspaceType) BootStatements(c ConnectionConfig) []string {
+	return []string{attachFile(c.Name, WorkspaceDatabase(c.ConnString), c.EnableWrite)}
+}
+
+func (t workspaceType) Validate(c ConnectionConfig) error {
+	return ValidateName(c.ConnString)
+}
+
+// scanType exposes a table format that is read with a scan function rather than attached,
+// as a temp view named after the connection.
+type scanType struct {
+	BaseType
+	function string
+	// args are appended to the scan function's arguments.
+	args string
+}
+
+func (t scanType) BootStatements(c ConnectionConfig) []string {
+	return []string{fmt.Sprintf("CREATE OR REPLACE TEMP VIEW %s AS SELECT * FROM %s(%s%s);", c.Name, t.function, quoteLiteral(c.ConnString), t.args)}
+}
+
+// ducklakeType attaches a DuckLake catalog from its metadata database and data path.
+type ducklakeType struct{ BaseType }
+
+func (t ducklakeType) BootStatements(c ConnectionConfig) []string {
+	options := optionValues(c.ConnString)
+	var attachOptions []string
+	if options["data_path"] != "" {
+		attachOptions = append(attachOptions, "DATA_PATH "+quoteLiteral(options["data_path"]))
+	}
+	if !c.EnableWrite {
+		attachOptions = append(attachOptions, "READ_ONLY")
+	}
+	statement := fmt.Sprintf("ATTACH IF NOT EXISTS %s as %s", quoteLiteral("ducklake:"+options["metadata"]), c.Name)
+	if len(attachOptions) > 0 {
+		statement += " (" + strings.Join(attachOptions, ", ") + ")"
+	}
+	return []string{statement + ";"}
+}
+
+func (t ducklakeType) Validate(c ConnectionConfig) error {
+	if optionValues(c.ConnString)["metadata"] == "" {
+		return fmt.Errorf("DUCKLAKE connections need a metadata=... option")
+	}
+	return nil
+}
+
+// storageType registers object storage credentials with CREATE SECRET.
+type storageType struct {
+	BaseType
+	// schemes are the URL prefixes of the paths the credentials are used for.
+	schemes []string
+}
+
+func (t storageType) BootStatements(c ConnectionConfig) []string {
+	return []string{c.SecretStatement()}
+}
+
+// httpsfsType is the type S3 connections were saved with before the storage types existed.
+// Before that dt attached the connection string itself, so a saved one may be the URL of a
+// remote database rather than options.
+type httpsfsType struct{ storageType }
+
+func (t httpsfsType) BootStatements(c ConnectionConfig) []string {
+	if legacyURL(c.ConnString) {
+		return []string{attachFile(c.Name, c.ConnString, false)}
+	}
+	return t.storageType.BootStatements(c)
+}
+
+func (t httpsfsType) Validate(c ConnectionConfig) error {
+	if legacyURL(c.ConnString) {
+		if c.EnableWrite {
+			return fmt.Errorf("HTTPSFS connection %s attaches the remote database at %s, which is read-only", c.Name, c.ConnString)
+		}
+		return nil
+	}
+	if strings.TrimSpace(c.ConnString) != "" && len(parseKeyValues(c.ConnString)) == 0 {
+		return fmt.Errorf("HTTPSFS connection %s has neither key=value options such as region=us-east-1 nor a database URL; save it again as an S3 connection", c.Name)
+	}
+	return nil
+}
+
+// legacyURL reports whether an HTTPSFS connection string is a URL rather than options. An
+// option's value may be a URL too, as in scope=s3://bucket, so the URL has to come first.
+func legacyURL(connString string) bool {
+	scheme := strings.Index(connString, "://")
+	eq := strings.IndexByte(connString, '=')
+	return scheme >= 0 && (eq < 0 || scheme < eq)
+}
+
+var (
+	scopeField = Field{Key: "scope", Title: "Scope", Description: "Limit the credentials to a prefix, e.g. s3://bucket/path"}
+
+	s3Fields = []Field{
+		{Key: "provider", Title: "Provider", Description: "credential_chain reads the AWS environment, profile or instance credentials", Options: []string{"", "config", "credential_chain"}},
+		{Key: "region", Title: "Region", Default: "us-east-1"},
+		{Key: "endpoint", Title: "Endpoint", Description: "Leave empty for AWS, or set for S3 compatible storage such as MinIO, e.g. localhost:9000"},
+		{Key: "key_id", Title: "Access key id"},
+		{Key: "secret", Title: "Secret access key", Description: "Or a reference such as ${env:AWS_SECRET_ACCESS_KEY}", Secret: true},
+		{Key: "session_token", Title: "Session token", Secret: true},
+		{Key: "url_style", Title: "URL style", Options: []string{"", "vhost", "path"}},
+		{Key: "use_ssl", Title: "Use SSL", Options: []string{"", "true", "false"}},
+		scopeField,
+	}
+
+	httpfs    = []string{"httpfs"}
+	s3Schemes = []string{"s3://", "s3a://", "s3n://"}
+)
+
+func init() {
+	Register(BaseType{
+		TypeName: "POSTGRES", TypeLabel: "PostgreSql", TypeKind: KindCatalog, Caps: Capabilities{Write: true},
+		FormFields: []Field{
+			{Key: "host", Title: "Host", Default: "localhost", Required: true},
+			{Key: "port", Title: "Port", Default: "5432", Validate: validatePort},
+			{Key: "dbname", Title: "Database", Required: true},
+			{Key: "user", Title: "User"},
+			{Key: "password", Title: "Password", Description: "Or a reference such as ${env:PG_PASSWORD}", Secret: true},
+			{Key: "sslmode", Title: "SSL mode", Options: []string{"", "disable", "allow", "prefer", "require", "verify-ca", "verify-full"}},
+		},
+	})
+	Register(BaseType{
+		TypeName: "MYSQL", TypeLabel: "MySql", TypeKind: KindCatalog, Caps: Capabilities{Write: true},
+		FormFields: []Field{
+			{Key: "host", Title: "Host", Default: "localhost", Required: true},
+			{Key: "port", Title: "Port", Default: "3306", Validate: validatePort},
+			{Key: "database", Title: "Database", Required: true},
+			{Key: "user", Title: "User"},
+			{Key: "password", Title: "Password", Description: "Or a reference such as ${env:MYSQL_PASSWORD}", Secret: true},
+			{Key: "ssl_mode", Title: "SSL mode", Options: []string{"", "disabled", "preferred", "required", "verify_ca", "verify_identity"}},
+		},
+	})
+	Register(BaseType{
+		TypeName: "SQLITE", TypeLabel: "SQLite", TypeKind: KindCatalog, Caps: Capabilities{Write: true}, ConnFormat: FormatPath,
+		FormFields: []Field{{Key: "path", Title: "Database file", Required: true, File: true}},
+	})
+	Register(duckdbType{BaseType{
+		TypeName: "DUCKDB", TypeLabel: "DuckDB database file", TypeKind: KindCatalog, Caps: Capabilities{Write: true}, ConnFormat: FormatPath,
+		FormFields: []Field{{Key: "path", Title: "Database file", Required: true, File: true}},
+		Requires:   []string{},
+	}})
+	Register(workspaceType{BaseType{
+		TypeName: "WORKSPACE", TypeLabel: "Another dt workspace", TypeKind: KindCatalog, Caps: Capabilities{Write: true}, ConnFormat: FormatPath,
+		FormFields: []Field{{Key: "workspace", Title: "Workspace", Required: true}},
+		Requires:   []string{},
+	}})
+	Register(scanType{
+		BaseType: BaseType{
+			TypeName: "ICEBERG", TypeLabel: "Iceberg table", TypeKind: KindView, ConnFormat: FormatPath,
+			FormFields: []Field{{Key: "location", Title: "Table location", Description: "A local path or s3:// URL of the table's root", Required: true}},
+		},
+		function: "iceberg_scan",
+		args:     ", allow_moved_paths = true",
+	})
+	Register(scanType{
+		BaseType: BaseType{
+			TypeName: "DELTA", TypeLabel: "Delta table", TypeKind: KindView, ConnFormat: FormatPath,
+			FormFields: []Field{{Key: "location", Title: "Table location", Description: "A local path or s3:// URL of the table's root", Required: true}},
+		},
+		function: "delta_scan",
+	})
+	Register(ducklakeType{BaseType{
+		TypeName: "DUCKLAKE", TypeLabel: "DuckLake catalog", TypeKind: KindCatalog, Caps: Capabilities{Write: true}, ConnFormat: FormatOptions,
+		FormFields: []Field{
+			{Key: "metadata", Title: "Metadata catalog", Description: "A DuckDB file such as metadata.ducklake, or e.g. postgres:dbname=lake", Required: true},
+			{Key: "data_path", Title: "Data path", Description: "Where the data files are written, e.g. s3://bucket/lake/"},
+		},
+	}})
+	Register(storageType{BaseType{
+		TypeName: "S3", TypeLabel: "S3 or S3 compatible storage", TypeKind: KindSecret, ConnFormat: FormatOptions,
+		FormFields: s3Fields, Requires: httpfs,
+	}, s3Schemes})
+	Register(storageType{BaseType{
+		TypeName: "GCS", TypeLabel: "Google Cloud Storage", TypeKind: KindSecret, ConnFormat: FormatOptions, Requires: httpfs,
+		FormFields: []Field{
+			{Key: "key_id", Title: "HMAC key id", Required: true},
+			{Key: "secret", Title: "HMAC secret", Description: "Or a reference such as ${env:GCS_SECRET}", Secret: true, Required: true},
+			scopeField,
+		},
+	}, []string{"gcs://", "gs://"}})
+	Register(storageType{BaseType{
+		TypeName: "R2", TypeLabel: "Cloudflare R2", TypeKind: KindSecret, ConnFormat: FormatOptions, Requires: httpfs,
+		FormFields: []Field{
+			{Key: "account_id", Title: "Account id", Required: true},
+			{Key: "key_id", Title: "Access key id", Required: true},
+			{Key: "secret", Title: "Secret access key", Description: "Or a reference such as ${env:R2_SECRET}", Secret: true, Required: true},
+			scopeField,
+		},
+	}, []string{"r2://"}})
+	Register(storageType{BaseType{
+		TypeName: "AZURE", TypeLabel: "Azure Blob Storage", TypeKind: KindSecret, ConnFormat: FormatOptions, Requires: []string{"azure"},
+		FormFields: []Field{
+			{Key: "provider", Title: "Provider", Options: []string{"", "config", "credential_chain", "service_principal"}},
+			{Key: "connection_string", Title: "Connection string", Description: "Or a reference such as ${env:AZURE_STORAGE_CONNECTION_STRING}", Secret: true},
+			{Key: "account_name", Title: "Account name"},
+			{Key: "tenant_id", Title: "Tenant id", Description: "For the service_principal provider"},
+			{Key: "client_id", Title: "Client id", Description: "For the service_principal provider"},
+			{Key: "client_secret", Title: "Client secret", Description: "For the service_principal provider", Secret: true},
+			scopeField,
+		},
+	}, []string{"az://", "azure://", "abfss://"}})
+	// HTTPSFS connections are S3 connections saved before the storage types existed
+	Register(httpsfsType{storageType{BaseType{
+		TypeName: "HTTPSFS", TypeLabel: "S3 (httpfs)", TypeKind: KindSecret, Caps: Capabilities{Hidden: true}, ConnFormat: FormatOptions,
+		FormFields: s3Fields, Requires: httpfs,
+	}, s3Schemes}})
+}
@@ -0,0 +1,362 @@
+// Package daemon keeps a workspace database open in a background process so that dt
+// invocations can skip loading extensions and attaching connections. Requests and
+// streamed results are gob encoded over a Unix socket in the workspace folder.
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/SandwichLabs/duck-tape/output"
+	"github.com/SandwichLabs/duck-tape/param"
+)
+
+// SocketName is the file name of the daemon's socket in the workspace folder.
+const SocketName = "dt.sock"
+
+// Request operations.
+const (
+	OpQuery  = "query"
+	OpStatus = "status"
+	OpStop   = "stop"
+)
+
+// SocketPath returns the socket of the daemon for the workspace at workspacePath.
+func SocketPath(workspacePath string) string {
+	return filepath.Join(workspacePath, SocketName)
+}
+
+// Query is a script forwarded to the daemon along with the flags that control how it
+// runs and where its results go.
+type Query struct {
+	SQL string
+	// Dir is the caller's working directory, which relative paths are read from.
+	Dir string
+	// Connections are booted before the script runs, if the daemon hasn't already.
+	Connections       []connection.ConnectionConfig
+	OnConnectionError string
+	Params            param.Params
+	Format            string
+	Options           output.Options
+	AllResults        bool
+	// File is set when the last result is written to a file instead of streamed back.
+	File output.FileOptions
+}
+
+// Status describes a running daemon.
+type Status struct {
+	PID         int
+	Workspace   string
+	Database    string
+	Started     time.Time
+	LastUsed    time.Time
+	Requests    int
+	Connections []string
+	IdleTimeout time.Duration
+}
+
+type request struct {
+	Op    string
+	Query Query
+}
+
+// response frames are streamed back for a request. Output frames are followed by a
+// final frame with Done set.
+type response struct {
+	Output   []byte
+	Done     bool
+	Err      string
+	ExitCode int
+	Status   Status
+}
+
+// RemoteError is a failure reported by the daemon, with the exit code it maps to.
+type RemoteError struct {
+	Message string
+	Code    int
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// Handler runs a forwarded query, writing its formatted results to w.
+type Handler func(ctx context.Context, q Query, w io.Writer) error
+
+// Server answers requests on the daemon socket.
+type Server struct {
+	Handler Handler
+	// ExitCode maps a handler error to the exit code the client reports.
+	ExitCode func(error) int
+	// IdleTimeout stops the server when no request has arrived for this long. Zero disables it.
+	IdleTimeout time.Duration
+	// Connections lists the connections the daemon has attached, for status.
+	Connections func() []string
+
+	mu       sync.Mutex
+	status   Status
+	active   int
+	listener net.Listener
+	stopping bool
+}
+
+// Listen creates the socket at path, replacing a stale socket left by a daemon that
+// didn't shut down cleanly.
+func Listen(path string) (net.Listener, error) {
+	if Running(path) {
+		return nil, fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	os.Remove(path)
+
+	// Queries run with the owner's credentials, so nobody else may connect. The socket is
+	// created private rather than chmodded afterwards, which would leave a window to connect.
+	var listener net.Listener
+	err := withPrivateUmask(func() error {
+		var err error
+		listener, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serve accepts requests until the daemon is stopped or idles out. The status is
+// reported with the workspace and database it describes.
+func (s *Server) Serve(listener net.Listener, workspace string, database string) error {
+	now := time.Now()
+	s.mu.Lock()
+	s.listener = listener
+	s.status = Status{PID: os.Getpid(), Workspace: workspace, Database: database, Started: now, LastUsed: now, IdleTimeout: s.IdleTimeout}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	if s.IdleTimeout > 0 {
+		go s.watchIdle(done)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			stopping := s.stopping
+			s.mu.Unlock()
+			if stopping {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(c)
+		}()
+	}
+}
+
+// stop closes the listener so Serve returns once the requests in flight finish.
+func (s *Server) stop(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return
+	}
+	slog.Info("Stopping daemon", "reason", reason)
+	s.stopping = true
+	s.listener.Close()
+}
+
+func (s *Server) watchIdle(done chan struct{}) {
+	ticker := time.NewTicker(min(s.IdleTimeout/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			idle := s.active == 0 && time.Since(s.status.LastUsed) >= s.IdleTimeout
+			s.mu.Unlock()
+			if idle {
+				s.stop("idle timeout")
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer c.Close()
+
+	s.mu.Lock()
+	s.active++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.status.LastUsed = time.Now()
+		s.mu.Unlock()
+	}()
+
+	var req request
+	if err := gob.NewDecoder(c).Decode(&req); err != nil {
+		// Running connects without sending a request
+		if !errors.Is(err, io.EOF) {
+			slog.Warn("Invalid daemon request", "error", err)
+		}
+		return
+	}
+	enc := gob.NewEncoder(c)
+
+	switch req.Op {
+	case OpStatus:
+		enc.Encode(response{Done: true, Status: s.Status()})
+	case OpStop:
+		enc.Encode(response{Done: true})
+		s.stop("stop requested")
+	case OpQuery:
+		s.mu.Lock()
+		s.status.Requests++
+		s.mu.Unlock()
+
+		w := bufio.NewWriterSize(&frameWriter{enc: enc}, 32*1024)
+		err := s.Handler(context.Background(), req.Query, w)
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+
+		final := response{Done: true}
+		if err != nil {
+			final.Err = err.Error()
+			final.ExitCode = 1
+			if s.ExitCode != nil {
+				final.ExitCode = s.ExitCode(err)
+			}
+		}
+		enc.Encode(final)
+	default:
+		enc.Encode(response{Done: true, Err: fmt.Sprintf("unknown daemon operation %q", req.Op), ExitCode: 1})
+	}
+}
+
+// Status reports the daemon's state.
+func (s *Server) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	if s.Connections != nil {
+		status.Connections = s.Connections()
+	}
+	return status
+}
+
+// frameWriter sends everything written to it as output frames.
+type frameWriter struct {
+	enc *gob.Encoder
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	if err := f.enc.Encode(response{Output: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Running reports whether a daemon is listening on the socket at path.
+func Running(path string) bool {
+	c, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// Client sends requests to the daemon listening on a socket.
+type Client struct {
+	Path string
+}
+
+// ErrNotRunning is returned when no daemon is listening on the socket.
+var ErrNotRunning = errors.New("daemon is not running")
+
+func (c Client) send(op string, q Query) (net.Conn, *gob.Decoder, error) {
+	conn, err := net.DialTimeout("unix", c.Path, time.Second)
+	if err != nil {
+		return nil, nil, ErrNotRunning
+	}
+	if err := gob.NewEncoder(conn).Encode(request{Op: op, Query: q}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, gob.NewDecoder(conn), nil
+}
+
+// Query runs q on the daemon and copies its output to w as it arrives.
+func (c Client) Query(q Query, w io.Writer) error {
+	conn, dec, err := c.send(OpQuery, q)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for {
+		var resp response
+		if err := dec.Decode(&resp); err != nil {
+			return fmt.Errorf("reading from daemon: %w", err)
+		}
+		if len(resp.Output) > 0 {
+			if _, err := w.Write(resp.Output); err != nil {
+				return err
+			}
+		}
+		if resp.Done {
+			if resp.Err != "" {
+				return &RemoteError{Message: resp.Err, Code: resp.ExitCode}
+			}
+			return nil
+		}
+	}
+}
+
+// Status asks the daemon for its status.
+func (c Client) Status() (Status, error) {
+	conn, dec, err := c.send(OpStatus, Query{})
+	if err != nil {
+		return Status{}, err
+	}
+	defer conn.Close()
+
+	var resp response
+	if err := dec.Decode(&resp); err != nil {
+		return Status{}, fmt.Errorf("reading from daemon: %w", err)
+	}
+	return resp.Status, nil
+}
+
+// Stop asks the daemon to shut down once the requests it is running finish.
+func (c Client) Stop() error {
+	conn, dec, err := c.send(OpStop, Query{})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var resp response
+	return dec.Decode(&resp)
+}
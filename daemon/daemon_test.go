@@ -0,0 +1,87 @@
+package daemon_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SandwichLabs/duck-tape/daemon"
+	"github.com/SandwichLabs/duck-tape/param"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon(t *testing.T) {
+	path := daemon.SocketPath(t.TempDir())
+	listener, err := daemon.Listen(path)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	// Only the owner may connect
+	assert.Zero(t, info.Mode().Perm()&0077)
+
+	server := &daemon.Server{
+		Handler: func(ctx context.Context, q daemon.Query, w io.Writer) error {
+			if q.SQL == "fail" {
+				return errors.New("query failed")
+			}
+			// Larger than a frame, so the output arrives in pieces
+			for i := 0; i < 10000; i++ {
+				fmt.Fprintf(w, "%s %v\n", q.SQL, q.Params[0].Value)
+			}
+			return nil
+		},
+		ExitCode:    func(error) int { return 4 },
+		Connections: func() []string { return []string{"pg"} },
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener, "test_workspace", "dt.db") }()
+
+	client := daemon.Client{Path: path}
+	assert.True(t, daemon.Running(path))
+
+	var out bytes.Buffer
+	err = client.Query(daemon.Query{SQL: "select", Params: param.Params{{Name: "id", Value: int64(7)}}}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("select 7\n"), 10000), out.Bytes())
+
+	err = client.Query(daemon.Query{SQL: "fail"}, io.Discard)
+	var remote *daemon.RemoteError
+	require.ErrorAs(t, err, &remote)
+	assert.Equal(t, "query failed", remote.Message)
+	assert.Equal(t, 4, remote.Code)
+
+	status, err := client.Status()
+	require.NoError(t, err)
+	assert.Equal(t, "test_workspace", status.Workspace)
+	assert.Equal(t, 2, status.Requests)
+	assert.Equal(t, []string{"pg"}, status.Connections)
+
+	// A second daemon can't take over the socket
+	_, err = daemon.Listen(path)
+	assert.Error(t, err)
+
+	require.NoError(t, client.Stop())
+	assert.NoError(t, <-served)
+	assert.False(t, daemon.Running(path))
+
+	_, err = client.Status()
+	assert.ErrorIs(t, err, daemon.ErrNotRunning)
+}
+
+func TestDaemonIdleTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), daemon.SocketName)
+	listener, err := daemon.Listen(path)
+	require.NoError(t, err)
+
+	server := &daemon.Server{IdleTimeout: 100 * time.Millisecond}
+	start := time.Now()
+	assert.NoError(t, server.Serve(listener, "test_workspace", "dt.db"))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
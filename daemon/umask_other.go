@@ -0,0 +1,7 @@
+//go:build !unix
+
+package daemon
+
+func withPrivateUmask(create func() error) error {
+	return create()
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package daemon
+
+import "syscall"
+
+// withPrivateUmask runs create with a umask that leaves new files readable and writable by
+// their owner only.
+func withPrivateUmask(create func() error) error {
+	old := syscall.Umask(0o077)
+	defer syscall.Umask(old)
+	return create()
+}
@@ -0,0 +1,46 @@
+package output
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// delimitedFormatter writes CSV or TSV using RFC 4180 quoting.
+// NULL values are written as empty fields.
+type delimitedFormatter struct {
+	w      *csv.Writer
+	enc    Encoder
+	header bool
+	types  []string
+	record []string
+}
+
+func newDelimited(w io.Writer, comma rune, opts Options) *delimitedFormatter {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &delimitedFormatter{w: cw, enc: opts.encoder(), header: opts.Header}
+}
+
+func (f *delimitedFormatter) WriteHeader(columns []Column) error {
+	f.record = make([]string, len(columns))
+	f.types = make([]string, len(columns))
+	for i, c := range columns {
+		f.types[i] = c.Type
+	}
+	if !f.header {
+		return nil
+	}
+	return f.w.Write(ColumnNames(columns))
+}
+
+func (f *delimitedFormatter) WriteRow(values []interface{}) error {
+	for i, v := range values {
+		f.record[i] = f.enc.Text(v, f.types[i], "")
+	}
+	return f.w.Write(f.record)
+}
+
+func (f *delimitedFormatter) Flush() error {
+	f.w.Flush()
+	return f.w.Error()
+}
@@ -0,0 +1,265 @@
+package output
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+// Encoder converts scanned go-duckdb values into a stable, lossless JSON representation.
+//
+// The mapping from DuckDB types is:
+//
+//	NULL                          null
+//	BOOLEAN                       true / false
+//	TINYINT .. UBIGINT            number (exact, no float rounding)
+//	HUGEINT, UHUGEINT             number (exact)
+//	FLOAT, DOUBLE                 number; NaN and ±Infinity as the strings "NaN", "Infinity", "-Infinity"
+//	DECIMAL(w,s)                  string such as "12.340", or an exact number when DecimalsAsNumbers is set
+//	VARCHAR, ENUM                 string
+//	JSON                          embedded JSON value
+//	BLOB                          base64 string (standard alphabet, padded)
+//	UUID                          string in 8-4-4-4-12 form
+//	DATE                          string "2006-01-02"
+//	TIME                          string "15:04:05.999999"
+//	TIMETZ                        string "15:04:05.999999Z07:00", normalised to UTC
+//	TIMESTAMP, TIMESTAMP_S/MS/NS  RFC3339 string with fractional seconds, in UTC
+//	TIMESTAMPTZ                   RFC3339 string with fractional seconds, in UTC
+//	INTERVAL                      object {"months": n, "days": n, "micros": n}
+//	LIST, ARRAY                   array, elements encoded by their own type
+//	STRUCT                        object with fields in declared order
+//	MAP                           object keyed by the textual form of each key, sorted by key
+//
+// UNION, BIT and VARINT are not supported by the driver; cast them to VARCHAR in the query.
+type Encoder struct {
+	DecimalsAsNumbers bool
+}
+
+// Object is a JSON object that keeps its keys in insertion order.
+type Object struct {
+	Keys   []string
+	Values []interface{}
+}
+
+func (o Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.Keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(o.Values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Row encodes a result row as an ordered object. Duplicate column names are
+// disambiguated with a numeric suffix (id, id_1, id_2, ...) instead of overwriting each other.
+func (e Encoder) Row(columns []Column, values []interface{}) Object {
+	row := Object{Keys: make([]string, len(columns)), Values: make([]interface{}, len(columns))}
+	seen := make(map[string]bool, len(columns))
+	for i, col := range columns {
+		key := col.Name
+		for n := 1; seen[key]; n++ {
+			key = fmt.Sprintf("%s_%d", col.Name, n)
+		}
+		seen[key] = true
+		row.Keys[i] = key
+		row.Values[i] = e.Value(values[i], col.Type)
+	}
+	return row
+}
+
+// Value encodes a single value of the given DuckDB type.
+func (e Encoder) Value(val interface{}, dbType string) interface{} {
+	if val == nil {
+		return nil
+	}
+
+	if elemType, ok := listElementType(dbType); ok {
+		if list, ok := val.([]interface{}); ok {
+			out := make([]interface{}, len(list))
+			for i, item := range list {
+				out[i] = e.Value(item, elemType)
+			}
+			return out
+		}
+	}
+
+	switch v := val.(type) {
+	case bool, string, int8, int16, int32, int64, uint8, uint16, uint32, uint64, int:
+		return v
+	case float32:
+		return encodeFloat(float64(v))
+	case float64:
+		return encodeFloat(v)
+	case *big.Int:
+		return json.Number(v.String())
+	case duckdb.Decimal:
+		if e.DecimalsAsNumbers {
+			return json.Number(formatDecimal(v))
+		}
+		return formatDecimal(v)
+	case []byte:
+		if dbType == "UUID" && len(v) == 16 {
+			return formatUUID(v)
+		}
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		return formatTime(v, dbType)
+	case duckdb.Interval:
+		return Object{
+			Keys:   []string{"months", "days", "micros"},
+			Values: []interface{}{v.Months, v.Days, v.Micros},
+		}
+	case map[string]interface{}:
+		return e.structValue(v, dbType)
+	case duckdb.Map:
+		return e.mapValue(v, dbType)
+	case []interface{}:
+		// JSON columns decode to plain arrays and objects.
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = e.Value(item, "")
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func (e Encoder) structValue(v map[string]interface{}, dbType string) Object {
+	fields, ok := structFields(dbType)
+	if !ok {
+		// Without a declared order (e.g. JSON objects) fall back to sorted keys.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields = make([]structField, len(keys))
+		for i, k := range keys {
+			fields[i] = structField{Name: k}
+		}
+	}
+	obj := Object{Keys: make([]string, len(fields)), Values: make([]interface{}, len(fields))}
+	for i, f := range fields {
+		obj.Keys[i] = f.Name
+		obj.Values[i] = e.Value(v[f.Name], f.Type)
+	}
+	return obj
+}
+
+func (e Encoder) mapValue(v duckdb.Map, dbType string) Object {
+	keyType, valueType, _ := mapTypes(dbType)
+	type entry struct {
+		key   string
+		value interface{}
+	}
+	entries := make([]entry, 0, len(v))
+	for k, val := range v {
+		entries = append(entries, entry{key: e.Text(k, keyType, "null"), value: e.Value(val, valueType)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	obj := Object{Keys: make([]string, len(entries)), Values: make([]interface{}, len(entries))}
+	for i, en := range entries {
+		obj.Keys[i] = en.key
+		obj.Values[i] = en.value
+	}
+	return obj
+}
+
+// Text renders a value as plain text for delimited and tabular formats.
+// Scalars use the same representation as the JSON encoding without quotes,
+// nested values are rendered as compact JSON and NULL is rendered as the null argument,
+// e.g. an empty field in CSV or NULL in a table.
+func (e Encoder) Text(val interface{}, dbType string, null string) string {
+	if val == nil {
+		return null
+	}
+	switch v := e.Value(val, dbType).(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case Object, []interface{}, map[string]interface{}:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(b)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func encodeFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
+
+func formatTime(t time.Time, dbType string) string {
+	switch dbType {
+	case "DATE":
+		return t.Format("2006-01-02")
+	case "TIME":
+		return t.Format("15:04:05.999999")
+	case "TIMETZ":
+		return t.UTC().Format("15:04:05.999999Z07:00")
+	default:
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// formatDecimal keeps the declared scale, so 12.340 stays 12.340 rather than 12.34.
+func formatDecimal(d duckdb.Decimal) string {
+	digits := new(big.Int).Abs(d.Value).String()
+	sign := ""
+	if d.Value.Sign() < 0 {
+		sign = "-"
+	}
+	scale := int(d.Scale)
+	if scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+func formatUUID(b []byte) string {
+	s := hex.EncodeToString(b)
+	return strings.Join([]string{s[0:8], s[8:12], s[12:16], s[16:20], s[20:32]}, "-")
+}
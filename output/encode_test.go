@@ -0,0 +1,65 @@
+package output_test
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+
+	"github.com/SandwichLabs/duck-tape/output"
+	_ "github.com/marcboeker/go-duckdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func queryJSON(t *testing.T, opts output.Options, query string) string {
+	db, err := sql.Open("duckdb", "")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query(query)
+	assert.NoError(t, err)
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	f, err := output.New("ndjson", &buf, opts)
+	assert.NoError(t, err)
+	assert.NoError(t, output.WriteRows(f, rows))
+	return buf.String()
+}
+
+func TestColumnOrderAndDuplicates(t *testing.T) {
+	out := queryJSON(t, output.Options{}, "SELECT 1 AS z, 2 AS a, 3 AS z")
+	assert.Equal(t, `{"z":1,"a":2,"z_1":3}`+"\n", out)
+}
+
+func TestTypeMapping(t *testing.T) {
+	out := queryJSON(t, output.Options{}, `SELECT
+		12.340::DECIMAL(10,3) AS dec,
+		170141183460469231731687303715884105727::HUGEINT AS huge,
+		'nan'::DOUBLE AS nan,
+		'\xAA\xBB'::BLOB AS blob,
+		'6f1c2a4e-8b7d-4c3a-9e2f-1a2b3c4d5e6f'::UUID AS id,
+		DATE '2024-02-29' AS d,
+		TIME '13:45:01.5' AS t,
+		TIMESTAMP '2024-02-29 13:45:01.123456' AS ts,
+		INTERVAL '1 month 2 days 3 seconds' AS iv,
+		[1, 2, NULL] AS list,
+		{'b': 1, 'a': {'y': 'x'}} AS st,
+		MAP {'k2': 2, 'k1': 1} AS m`)
+	assert.Equal(t, `{"dec":"12.340",`+
+		`"huge":170141183460469231731687303715884105727,`+
+		`"nan":"NaN",`+
+		`"blob":"qrs=",`+
+		`"id":"6f1c2a4e-8b7d-4c3a-9e2f-1a2b3c4d5e6f",`+
+		`"d":"2024-02-29",`+
+		`"t":"13:45:01.5",`+
+		`"ts":"2024-02-29T13:45:01.123456Z",`+
+		`"iv":{"months":1,"days":2,"micros":3000000},`+
+		`"list":[1,2,null],`+
+		`"st":{"b":1,"a":{"y":"x"}},`+
+		`"m":{"k1":1,"k2":2}}`+"\n", out)
+}
+
+func TestDecimalsAsNumbers(t *testing.T) {
+	out := queryJSON(t, output.Options{DecimalsAsNumbers: true}, "SELECT 12.340::DECIMAL(10,3) AS dec")
+	assert.Equal(t, `{"dec":12.340}`+"\n", out)
+}
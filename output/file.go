@@ -0,0 +1,123 @@
+package output
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FileOptions describes a query export written by DuckDB's COPY ... TO.
+type FileOptions struct {
+	Path string
+	// Format is one of parquet, csv, json or ndjson. It is inferred from Path when empty.
+	Format string
+	// Compression is one of none, gzip, zstd (or snappy for parquet). It is inferred
+	// from a trailing .gz or .zst extension when empty.
+	Compression string
+	// RowGroupSize sets the parquet row group size in rows. Zero keeps DuckDB's default.
+	RowGroupSize int
+	// PartitionBy writes a hive-style partitioned directory tree at Path.
+	PartitionBy []string
+	Header      bool
+}
+
+var fileFormats = map[string]string{
+	".parquet": "parquet",
+	".csv":     "csv",
+	".tsv":     "tsv",
+	".json":    "json",
+	".ndjson":  "ndjson",
+	".jsonl":   "ndjson",
+}
+
+var compressionExtensions = map[string]string{
+	".gz":  "gzip",
+	".zst": "zstd",
+}
+
+// InferFile fills in Format and Compression from the file extension when they are not set.
+func InferFile(opts FileOptions) (FileOptions, error) {
+	ext := strings.ToLower(filepath.Ext(opts.Path))
+	if compression, ok := compressionExtensions[ext]; ok {
+		if opts.Compression == "" {
+			opts.Compression = compression
+		}
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(opts.Path, filepath.Ext(opts.Path))))
+	}
+	if opts.Format == "" {
+		format, ok := fileFormats[ext]
+		if !ok {
+			return opts, fmt.Errorf("cannot infer output format from %q, set it with --output-format", opts.Path)
+		}
+		opts.Format = format
+	}
+	opts.Format = strings.ToLower(opts.Format)
+	opts.Compression = strings.ToLower(opts.Compression)
+	return opts, nil
+}
+
+// CopyStatement wraps query in a COPY ... TO statement that writes it to opts.Path.
+func CopyStatement(query string, opts FileOptions) (string, error) {
+	opts, err := InferFile(opts)
+	if err != nil {
+		return "", err
+	}
+
+	var options []string
+	switch opts.Format {
+	case "parquet":
+		options = append(options, "FORMAT PARQUET")
+		if opts.RowGroupSize > 0 {
+			options = append(options, fmt.Sprintf("ROW_GROUP_SIZE %d", opts.RowGroupSize))
+		}
+	case "csv", "tsv":
+		options = append(options, "FORMAT CSV", fmt.Sprintf("HEADER %t", opts.Header))
+		if opts.Format == "tsv" {
+			options = append(options, "DELIMITER '\\t'")
+		}
+	case "json":
+		options = append(options, "FORMAT JSON", "ARRAY true")
+	case "ndjson":
+		options = append(options, "FORMAT JSON")
+	default:
+		return "", fmt.Errorf("unsupported output file format %q (expected parquet, csv, tsv, json or ndjson)", opts.Format)
+	}
+
+	if opts.RowGroupSize > 0 && opts.Format != "parquet" {
+		return "", fmt.Errorf("row group size only applies to parquet output")
+	}
+
+	switch opts.Compression {
+	case "", "none":
+	case "gzip", "zstd":
+		options = append(options, fmt.Sprintf("COMPRESSION %s", opts.Compression))
+	case "snappy":
+		if opts.Format != "parquet" {
+			return "", fmt.Errorf("snappy compression only applies to parquet output")
+		}
+		options = append(options, "COMPRESSION snappy")
+	default:
+		return "", fmt.Errorf("unsupported compression %q (expected none, gzip or zstd)", opts.Compression)
+	}
+
+	if len(opts.PartitionBy) > 0 {
+		columns := make([]string, len(opts.PartitionBy))
+		for i, col := range opts.PartitionBy {
+			columns[i] = QuoteIdentifier(col)
+		}
+		options = append(options, fmt.Sprintf("PARTITION_BY (%s)", strings.Join(columns, ", ")))
+	}
+
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	return fmt.Sprintf("COPY (%s) TO %s (%s)", query, QuoteLiteral(opts.Path), strings.Join(options, ", ")), nil
+}
+
+// QuoteIdentifier quotes a SQL identifier, doubling any embedded double quotes.
+func QuoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// QuoteLiteral quotes a SQL string literal, doubling any embedded single quotes.
+func QuoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
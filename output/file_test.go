@@ -0,0 +1,38 @@
+package output_test
+
+import (
+	"testing"
+
+	"github.com/SandwichLabs/duck-tape/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyStatementInfersFormat(t *testing.T) {
+	stmt, err := output.CopyStatement("select 1;", output.FileOptions{Path: "out.csv.gz", Header: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "COPY (select 1) TO 'out.csv.gz' (FORMAT CSV, HEADER true, COMPRESSION gzip)", stmt)
+
+	stmt, err = output.CopyStatement("select 1", output.FileOptions{Path: "it's.ndjson"})
+	assert.NoError(t, err)
+	assert.Equal(t, "COPY (select 1) TO 'it''s.ndjson' (FORMAT JSON)", stmt)
+}
+
+func TestCopyStatementParquetOptions(t *testing.T) {
+	stmt, err := output.CopyStatement("select * from t", output.FileOptions{
+		Path:         "out",
+		Format:       "parquet",
+		Compression:  "zstd",
+		RowGroupSize: 100000,
+		PartitionBy:  []string{"year", "month"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `COPY (select * from t) TO 'out' (FORMAT PARQUET, ROW_GROUP_SIZE 100000, COMPRESSION zstd, PARTITION_BY ("year", "month"))`, stmt)
+}
+
+func TestCopyStatementErrors(t *testing.T) {
+	_, err := output.CopyStatement("select 1", output.FileOptions{Path: "out.txt"})
+	assert.Error(t, err)
+
+	_, err = output.CopyStatement("select 1", output.FileOptions{Path: "out.csv", RowGroupSize: 10})
+	assert.Error(t, err)
+}
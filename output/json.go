@@ -0,0 +1,78 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type ndjsonFormatter struct {
+	w       io.Writer
+	enc     Encoder
+	columns []Column
+}
+
+func newNDJSON(w io.Writer, enc Encoder) *ndjsonFormatter {
+	return &ndjsonFormatter{w: w, enc: enc}
+}
+
+func (f *ndjsonFormatter) WriteHeader(columns []Column) error {
+	f.columns = columns
+	return nil
+}
+
+func (f *ndjsonFormatter) WriteRow(values []interface{}) error {
+	b, err := json.Marshal(f.enc.Row(f.columns, values))
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = f.w.Write(b)
+	return err
+}
+
+func (f *ndjsonFormatter) Flush() error {
+	return nil
+}
+
+// jsonArrayFormatter writes every row as an element of a single JSON array.
+type jsonArrayFormatter struct {
+	w       io.Writer
+	enc     Encoder
+	columns []Column
+	count   int
+}
+
+func newJSONArray(w io.Writer, enc Encoder) *jsonArrayFormatter {
+	return &jsonArrayFormatter{w: w, enc: enc}
+}
+
+func (f *jsonArrayFormatter) WriteHeader(columns []Column) error {
+	f.columns = columns
+	return nil
+}
+
+func (f *jsonArrayFormatter) WriteRow(values []interface{}) error {
+	b, err := json.Marshal(f.enc.Row(f.columns, values))
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if f.count == 0 {
+		sep = "[\n"
+	}
+	f.count++
+	if _, err := io.WriteString(f.w, sep); err != nil {
+		return err
+	}
+	_, err = f.w.Write(b)
+	return err
+}
+
+func (f *jsonArrayFormatter) Flush() error {
+	if f.count == 0 {
+		_, err := io.WriteString(f.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(f.w, "\n]\n")
+	return err
+}
@@ -0,0 +1,50 @@
+package output
+
+import (
+	"io"
+	"strings"
+)
+
+type markdownFormatter struct {
+	w       io.Writer
+	enc     Encoder
+	columns []Column
+}
+
+func newMarkdown(w io.Writer, enc Encoder) *markdownFormatter {
+	return &markdownFormatter{w: w, enc: enc}
+}
+
+func (f *markdownFormatter) WriteHeader(columns []Column) error {
+	f.columns = columns
+	var md strings.Builder
+	names := ColumnNames(columns)
+	for i, name := range names {
+		names[i] = escapeCell(name)
+	}
+	md.WriteString("| " + strings.Join(names, " | ") + " |\n")
+	md.WriteString("|" + strings.Repeat("---|", len(columns)) + "\n")
+	_, err := io.WriteString(f.w, md.String())
+	return err
+}
+
+func (f *markdownFormatter) WriteRow(values []interface{}) error {
+	var md strings.Builder
+	md.WriteString("| ")
+	for i, v := range values {
+		md.WriteString(escapeCell(f.enc.Text(v, f.columns[i].Type, "NULL")))
+		md.WriteString(" | ")
+	}
+	md.WriteString("\n")
+	_, err := io.WriteString(f.w, md.String())
+	return err
+}
+
+// escapeCell escapes the pipes in a header or body cell, which would otherwise start a new cell.
+func escapeCell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+func (f *markdownFormatter) Flush() error {
+	return nil
+}
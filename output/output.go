@@ -0,0 +1,98 @@
+/*
+Copyright © 2024 Zac Orndorff zac@orndorff.dev
+*/
+package output
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Formatter writes a result set to an output stream.
+// WriteHeader is called once with the column names before any rows,
+// and Flush is called once after the last row.
+type Formatter interface {
+	WriteHeader(columns []Column) error
+	WriteRow(values []interface{}) error
+	Flush() error
+}
+
+type Options struct {
+	// Header controls whether delimited formats emit a header row.
+	Header bool
+	// DecimalsAsNumbers writes DECIMAL values as exact JSON numbers instead of strings.
+	DecimalsAsNumbers bool
+}
+
+func (o Options) encoder() Encoder {
+	return Encoder{DecimalsAsNumbers: o.DecimalsAsNumbers}
+}
+
+type factory func(w io.Writer, opts Options) Formatter
+
+var formats = map[string]factory{
+	"ndjson":   func(w io.Writer, opts Options) Formatter { return newNDJSON(w, opts.encoder()) },
+	"json":     func(w io.Writer, opts Options) Formatter { return newJSONArray(w, opts.encoder()) },
+	"csv":      func(w io.Writer, opts Options) Formatter { return newDelimited(w, ',', opts) },
+	"tsv":      func(w io.Writer, opts Options) Formatter { return newDelimited(w, '\t', opts) },
+	"markdown": func(w io.Writer, opts Options) Formatter { return newMarkdown(w, opts.encoder()) },
+	"md":       func(w io.Writer, opts Options) Formatter { return newMarkdown(w, opts.encoder()) },
+	"table":    func(w io.Writer, opts Options) Formatter { return newTable(w, opts.encoder()) },
+}
+
+// Names returns the supported format names in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the Formatter registered under name.
+func New(name string, w io.Writer, opts Options) (Formatter, error) {
+	f, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q (expected one of %s)", name, strings.Join(Names(), ", "))
+	}
+	return f(w, opts), nil
+}
+
+// WriteRows scans every row of rows and writes it through f.
+func WriteRows(f Formatter, rows *sql.Rows) error {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return fmt.Errorf("failed to get columns: %w", err)
+	}
+	columns := make([]Column, len(columnTypes))
+	for i, ct := range columnTypes {
+		columns[i] = Column{Name: ct.Name(), Type: ct.DatabaseTypeName()}
+	}
+	if err := f.WriteHeader(columns); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	scanArgs := make([]interface{}, len(values))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		if err := f.WriteRow(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return f.Flush()
+}
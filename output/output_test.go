@@ -0,0 +1,77 @@
+package output_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/SandwichLabs/duck-tape/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func render(t *testing.T, format string, opts output.Options, columns []output.Column, rows ...[]interface{}) string {
+	var buf bytes.Buffer
+	f, err := output.New(format, &buf, opts)
+	assert.NoError(t, err)
+	assert.NoError(t, f.WriteHeader(columns))
+	for _, row := range rows {
+		assert.NoError(t, f.WriteRow(row))
+	}
+	assert.NoError(t, f.Flush())
+	return buf.String()
+}
+
+func cols(names ...string) []output.Column {
+	columns := make([]output.Column, len(names))
+	for i, name := range names {
+		columns[i] = output.Column{Name: name}
+	}
+	return columns
+}
+
+func TestCSVQuoting(t *testing.T) {
+	out := render(t, "csv", output.Options{Header: true}, cols("id", "name"),
+		[]interface{}{int64(1), "a, \"quoted\" name"},
+		[]interface{}{int64(2), nil},
+	)
+	assert.Equal(t, "id,name\n1,\"a, \"\"quoted\"\" name\"\n2,\n", out)
+}
+
+func TestTSVWithoutHeader(t *testing.T) {
+	out := render(t, "tsv", output.Options{Header: false}, cols("id", "name"),
+		[]interface{}{int64(1), "x"},
+	)
+	assert.Equal(t, "1\tx\n", out)
+}
+
+func TestJSONArray(t *testing.T) {
+	out := render(t, "json", output.Options{}, cols("id"),
+		[]interface{}{int64(1)},
+		[]interface{}{int64(2)},
+	)
+	assert.Equal(t, "[\n{\"id\":1},\n{\"id\":2}\n]\n", out)
+
+	empty := render(t, "json", output.Options{}, cols("id"))
+	assert.Equal(t, "[]\n", empty)
+}
+
+func TestMarkdown(t *testing.T) {
+	out := render(t, "markdown", output.Options{}, cols("a", "b"),
+		[]interface{}{"x|y", nil},
+	)
+	assert.Equal(t, "| a | b |\n|---|---|\n| x\\|y | NULL | \n", out)
+
+	out = render(t, "markdown", output.Options{}, cols("a|b"), []interface{}{int64(1)})
+	assert.Equal(t, "| a\\|b |\n|---|\n| 1 | \n", out)
+}
+
+func TestTable(t *testing.T) {
+	out := render(t, "table", output.Options{}, cols("id", "name"),
+		[]interface{}{int64(10), "alice"},
+	)
+	assert.Equal(t, "id  name\n--  -----\n10  alice\n", out)
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := output.New("xml", &bytes.Buffer{}, output.Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package output
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// tableFormatter buffers the result set so that columns can be aligned.
+type tableFormatter struct {
+	w       io.Writer
+	enc     Encoder
+	columns []Column
+	rows    [][]string
+}
+
+func newTable(w io.Writer, enc Encoder) *tableFormatter {
+	return &tableFormatter{w: w, enc: enc}
+}
+
+func (f *tableFormatter) WriteHeader(columns []Column) error {
+	f.columns = columns
+	return nil
+}
+
+func (f *tableFormatter) WriteRow(values []interface{}) error {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = strings.ReplaceAll(f.enc.Text(v, f.columns[i].Type, "NULL"), "\n", "\\n")
+	}
+	f.rows = append(f.rows, row)
+	return nil
+}
+
+func (f *tableFormatter) Flush() error {
+	header := ColumnNames(f.columns)
+	widths := make([]int, len(header))
+	for i, col := range header {
+		widths[i] = utf8.RuneCountInString(col)
+	}
+	for _, row := range f.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var sb strings.Builder
+	writeLine := func(cells []string) {
+		for i, cell := range cells {
+			if i > 0 {
+				sb.WriteString("  ")
+			}
+			sb.WriteString(cell)
+			if i < len(cells)-1 {
+				sb.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	writeLine(header)
+	rule := make([]string, len(widths))
+	for i, w := range widths {
+		rule[i] = strings.Repeat("-", w)
+	}
+	writeLine(rule)
+	for _, row := range f.rows {
+		writeLine(row)
+	}
+
+	_, err := io.WriteString(f.w, sb.String())
+	return err
+}
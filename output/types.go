@@ -0,0 +1,124 @@
+package output
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Column describes a result column by name and DuckDB type name,
+// as reported by sql.ColumnType.DatabaseTypeName.
+type Column struct {
+	Name string
+	Type string
+}
+
+// ColumnNames returns the column names in result order.
+func ColumnNames(columns []Column) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// listElementType returns the element type of a LIST (T[]) or ARRAY (T[N]) type name.
+func listElementType(t string) (string, bool) {
+	if !strings.HasSuffix(t, "]") {
+		return "", false
+	}
+	open := strings.LastIndex(t, "[")
+	if open < 0 {
+		return "", false
+	}
+	size := t[open+1 : len(t)-1]
+	if size != "" {
+		if _, err := strconv.Atoi(size); err != nil {
+			return "", false
+		}
+	}
+	return t[:open], true
+}
+
+type structField struct {
+	Name string
+	Type string
+}
+
+// structFields parses the field list of a STRUCT("a" INTEGER, "b" VARCHAR) type name,
+// preserving the declared field order.
+func structFields(t string) ([]structField, bool) {
+	inner, ok := typeArgs(t, "STRUCT")
+	if !ok {
+		return nil, false
+	}
+	var fields []structField
+	for _, part := range splitTopLevel(inner) {
+		name, rest := parseFieldName(part)
+		fields = append(fields, structField{Name: name, Type: strings.TrimSpace(rest)})
+	}
+	return fields, true
+}
+
+// mapTypes parses the key and value types of a MAP(K, V) type name.
+func mapTypes(t string) (string, string, bool) {
+	inner, ok := typeArgs(t, "MAP")
+	if !ok {
+		return "", "", false
+	}
+	parts := splitTopLevel(inner)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func typeArgs(t string, prefix string) (string, bool) {
+	if !strings.HasPrefix(t, prefix+"(") || !strings.HasSuffix(t, ")") {
+		return "", false
+	}
+	return t[len(prefix)+1 : len(t)-1], true
+}
+
+// splitTopLevel splits on commas that are not nested in parentheses or quotes.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// parseFieldName reads a possibly double-quoted field name and returns it with the remainder.
+func parseFieldName(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		name, rest, _ := strings.Cut(s, " ")
+		return name, rest
+	}
+	var name strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != '"' {
+			name.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '"' {
+			name.WriteByte('"')
+			i++
+			continue
+		}
+		return name.String(), s[i+1:]
+	}
+	return name.String(), ""
+}
@@ -0,0 +1,425 @@
+/*
+Copyright © 2024 Zac Orndorff zac@orndorff.dev
+*/
+package param
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/script"
+)
+
+// Param is a query parameter parsed from the command line or a params file.
+type Param struct {
+	// Name is empty for positional parameters.
+	Name string
+	// Type is the DuckDB type the value is cast to, or empty to let DuckDB infer it.
+	Type string
+	// Value is nil for NULL.
+	Value interface{}
+}
+
+// Params is an ordered set of either positional or named parameters.
+type Params []Param
+
+// specPattern matches the name:type= prefix of a -p value. Both parts are optional,
+// so "5" is positional, "id=5" is named, "id:int=5" is named and typed and ":int=5" is
+// positional and typed.
+var specPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)?(?::([A-Za-z_][A-Za-z0-9_ ]*(?:\([0-9, ]+\))?(?:\[\])?))?=`)
+
+// nullPattern matches a bare name:null or :null.
+var nullPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)?:null$`)
+
+var typeAliases = map[string]string{
+	"int":         "INTEGER",
+	"integer":     "INTEGER",
+	"bigint":      "BIGINT",
+	"smallint":    "SMALLINT",
+	"tinyint":     "TINYINT",
+	"hugeint":     "HUGEINT",
+	"ubigint":     "UBIGINT",
+	"float":       "FLOAT",
+	"double":      "DOUBLE",
+	"real":        "FLOAT",
+	"bool":        "BOOLEAN",
+	"boolean":     "BOOLEAN",
+	"string":      "VARCHAR",
+	"text":        "VARCHAR",
+	"varchar":     "VARCHAR",
+	"date":        "DATE",
+	"time":        "TIME",
+	"timestamp":   "TIMESTAMP",
+	"datetime":    "TIMESTAMP",
+	"timestamptz": "TIMESTAMPTZ",
+	"interval":    "INTERVAL",
+	"uuid":        "UUID",
+	"json":        "JSON",
+	"blob":        "BLOB",
+}
+
+// Parse reads a single -p value. Values without a name or type are passed
+// through as strings, exactly as they always have been.
+func Parse(spec string) (Param, error) {
+	if m := nullPattern.FindStringSubmatch(spec); m != nil {
+		return Param{Name: m[1]}, nil
+	}
+
+	m := specPattern.FindStringSubmatchIndex(spec)
+	if m == nil {
+		return Param{Value: spec}, nil
+	}
+	p := Param{Name: submatch(spec, m, 1)}
+	raw := spec[m[1]:]
+
+	typeName := submatch(spec, m, 2)
+	if typeName == "" {
+		if p.Name == "" {
+			// A bare "=value" is just a positional string
+			return Param{Value: spec}, nil
+		}
+		p.Value = raw
+		return p, nil
+	}
+
+	var err error
+	p.Type, err = normalizeType(typeName)
+	if err != nil {
+		return Param{}, err
+	}
+	if raw == "" && p.Type != "VARCHAR" && p.Type != "BLOB" {
+		// An empty value is ambiguous for anything but a string, so NULL has to be asked for
+		return Param{}, fmt.Errorf("empty value for %s parameter %s, pass %s:null for NULL", p.Type, p.label(), p.Name)
+	}
+	p.Value, err = convert(p.Type, raw)
+	if err != nil {
+		return Param{}, fmt.Errorf("invalid value for parameter %s: %w", p.label(), err)
+	}
+	return p, nil
+}
+
+// ParseAll parses every -p value and checks that positional and named parameters are not mixed.
+func ParseAll(specs []string) (Params, error) {
+	params := make(Params, 0, len(specs))
+	for _, spec := range specs {
+		p, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, p)
+	}
+	return params, params.validate()
+}
+
+// FromJSON reads parameters from a JSON object of name to value, or a JSON array of positional values.
+// Object keys may carry a type annotation, as in {"id:int": 5}. Values keep their JSON types:
+// numbers bind as BIGINT or DOUBLE, arrays as lists and null as NULL.
+func FromJSON(r io.Reader) (Params, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse params file: %w", err)
+	}
+
+	var params Params
+	switch v := raw.(type) {
+	case []interface{}:
+		for _, item := range v {
+			p, err := fromJSONValue(Param{}, item)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, p)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		// Sort for stable error messages; named parameters are order independent
+		sort.Strings(keys)
+		for _, key := range keys {
+			name, typeName, _ := strings.Cut(key, ":")
+			p := Param{Name: name}
+			if typeName != "" {
+				var err error
+				if p.Type, err = normalizeType(typeName); err != nil {
+					return nil, err
+				}
+			}
+			p, err := fromJSONValue(p, v[key])
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, p)
+		}
+	default:
+		return nil, errors.New("params file must contain a JSON object or array")
+	}
+	return params, params.validate()
+}
+
+// Merge appends other to p, with later named values replacing earlier ones.
+func (p Params) Merge(other Params) (Params, error) {
+	merged := append(Params{}, p...)
+	for _, o := range other {
+		replaced := false
+		for i := range merged {
+			if o.Name != "" && merged[i].Name == o.Name {
+				merged[i] = o
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, o)
+		}
+	}
+	return merged, merged.validate()
+}
+
+// Named reports whether the parameters are bound by name.
+func (p Params) Named() bool {
+	return len(p) > 0 && p[0].Name != ""
+}
+
+// Bind returns the statement rewritten so that typed parameters are cast to their type,
+// along with the arguments to execute it with. Only the named parameters the statement
+// references are passed.
+func (p Params) Bind(statement script.Statement) (string, []interface{}, error) {
+	if !statement.HasParams {
+		return statement.SQL, nil, nil
+	}
+
+	if !p.Named() {
+		args := make([]interface{}, len(p))
+		for i, param := range p {
+			args[i] = param.Value
+		}
+		query := script.RewritePlaceholders(statement.SQL, func(ph script.Placeholder, text string) string {
+			if ph.Position > 0 && ph.Position <= len(p) {
+				return p[ph.Position-1].cast(text)
+			}
+			return text
+		})
+		return query, args, nil
+	}
+
+	args := make([]interface{}, 0, len(statement.ParamNames))
+	for _, name := range statement.ParamNames {
+		param, ok := p.lookup(name)
+		if !ok {
+			return "", nil, fmt.Errorf("missing value for parameter $%s", name)
+		}
+		args = append(args, sql.Named(name, param.Value))
+	}
+	query := script.RewritePlaceholders(statement.SQL, func(ph script.Placeholder, text string) string {
+		if param, ok := p.lookup(ph.Name); ok && ph.Name != "" {
+			return param.cast(text)
+		}
+		return text
+	})
+	return query, args, nil
+}
+
+func (p Params) lookup(name string) (Param, bool) {
+	for _, param := range p {
+		if strings.EqualFold(param.Name, name) {
+			return param, true
+		}
+	}
+	return Param{}, false
+}
+
+func (p Params) validate() error {
+	for _, param := range p {
+		if (param.Name != "") != p.Named() {
+			return errors.New("positional and named parameters cannot be mixed")
+		}
+	}
+	return nil
+}
+
+func (p Param) cast(text string) string {
+	if p.Type == "" {
+		return text
+	}
+	if strings.HasSuffix(p.Type, "[]") {
+		// Lists are bound as JSON text and converted through DuckDB's JSON casts
+		return fmt.Sprintf("CAST(CAST(%s AS JSON) AS %s)", text, p.Type)
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", text, p.Type)
+}
+
+func (p Param) label() string {
+	if p.Name == "" {
+		return "(positional)"
+	}
+	return "$" + p.Name
+}
+
+func normalizeType(typeName string) (string, error) {
+	typeName = strings.TrimSpace(typeName)
+	base, isList := strings.CutSuffix(typeName, "[]")
+	lower := strings.ToLower(base)
+
+	normalized, ok := typeAliases[lower]
+	if !ok {
+		if !strings.HasPrefix(lower, "decimal") && !strings.HasPrefix(lower, "numeric") {
+			return "", fmt.Errorf("unsupported parameter type %q", typeName)
+		}
+		normalized = strings.ToUpper(base)
+	}
+	if isList {
+		normalized += "[]"
+	}
+	return normalized, nil
+}
+
+// convert parses a command line value for a typed parameter. NULL is only ever passed as
+// name:null, never as a value. Numbers and booleans are converted so that obviously bad input
+// fails before the query runs; everything else is handed to DuckDB's CAST as a string.
+func convert(typeName string, raw string) (interface{}, error) {
+	if raw == "" && typeName != "VARCHAR" && typeName != "BLOB" {
+		return nil, fmt.Errorf("empty value for %s", typeName)
+	}
+
+	if elemType, ok := strings.CutSuffix(typeName, "[]"); ok {
+		return listJSON(elemType, raw)
+	}
+
+	switch typeName {
+	case "INTEGER", "BIGINT", "SMALLINT", "TINYINT":
+		return strconv.ParseInt(raw, 10, 64)
+	case "HUGEINT", "UBIGINT":
+		n, ok := new(big.Int).SetString(raw, 10)
+		if !ok {
+			return nil, fmt.Errorf("%q is not an integer", raw)
+		}
+		return n.String(), nil
+	case "FLOAT", "DOUBLE":
+		return strconv.ParseFloat(raw, 64)
+	case "BOOLEAN":
+		return strconv.ParseBool(raw)
+	default:
+		return raw, nil
+	}
+}
+
+// listJSON turns a JSON array or comma separated values into the JSON text bound for a list parameter.
+func listJSON(elemType string, raw string) (interface{}, error) {
+	trimmed := strings.TrimSpace(raw)
+	if strings.HasPrefix(trimmed, "[") {
+		if !json.Valid([]byte(trimmed)) {
+			return nil, fmt.Errorf("%q is not a valid JSON array", raw)
+		}
+		return trimmed, nil
+	}
+
+	items := strings.Split(raw, ",")
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		v, err := convert(elemType, strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	encoded, err := json.Marshal(values)
+	return string(encoded), err
+}
+
+func fromJSONValue(p Param, value interface{}) (Param, error) {
+	switch v := value.(type) {
+	case nil:
+		p.Value = nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			p.Value = i
+		} else {
+			f, err := v.Float64()
+			if err != nil {
+				return Param{}, fmt.Errorf("invalid number for parameter %s: %w", p.label(), err)
+			}
+			p.Value = f
+		}
+	case []interface{}, map[string]interface{}:
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		if err := encoder.Encode(v); err != nil {
+			return Param{}, err
+		}
+		p.Value = strings.TrimSpace(buf.String())
+		if p.Type == "" {
+			p.Type = inferListType(v)
+		}
+	default:
+		p.Value = v
+	}
+
+	// Convert string values for typed parameters the same way the command line does
+	if s, ok := p.Value.(string); ok && p.Type != "" && !strings.HasSuffix(p.Type, "[]") && p.Type != "JSON" {
+		converted, err := convert(p.Type, s)
+		if err != nil {
+			return Param{}, fmt.Errorf("invalid value for parameter %s: %w", p.label(), err)
+		}
+		p.Value = converted
+	}
+	return p, nil
+}
+
+// inferListType picks a list type for an untyped JSON array from its elements.
+func inferListType(value interface{}) string {
+	items, ok := value.([]interface{})
+	if !ok {
+		return "JSON"
+	}
+	elemType := ""
+	for _, item := range items {
+		var t string
+		switch v := item.(type) {
+		case nil:
+			continue
+		case json.Number:
+			t = "BIGINT"
+			if _, err := v.Int64(); err != nil {
+				t = "DOUBLE"
+			}
+		case bool:
+			t = "BOOLEAN"
+		case string:
+			t = "VARCHAR"
+		default:
+			return "JSON"
+		}
+		switch {
+		case elemType == "" || elemType == t:
+			elemType = t
+		case (elemType == "BIGINT" && t == "DOUBLE") || (elemType == "DOUBLE" && t == "BIGINT"):
+			elemType = "DOUBLE"
+		default:
+			return "JSON[]"
+		}
+	}
+	if elemType == "" {
+		elemType = "VARCHAR"
+	}
+	return elemType + "[]"
+}
+
+func submatch(s string, m []int, n int) string {
+	if m[2*n] < 0 {
+		return ""
+	}
+	return s[m[2*n]:m[2*n+1]]
+}
@@ -0,0 +1,144 @@
+package param_test
+
+import (
+	"database/sql"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/SandwichLabs/duck-tape/param"
+	"github.com/SandwichLabs/duck-tape/script"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		spec string
+		want param.Param
+	}{
+		{"hello", param.Param{Value: "hello"}},
+		{"a b=c", param.Param{Value: "a b=c"}},
+		{"name=alice", param.Param{Name: "name", Value: "alice"}},
+		{"id:int=5", param.Param{Name: "id", Type: "INTEGER", Value: int64(5)}},
+		{":double=1.5", param.Param{Type: "DOUBLE", Value: 1.5}},
+		{"ok:bool=true", param.Param{Name: "ok", Type: "BOOLEAN", Value: true}},
+		{"ts:timestamp=2024-01-01 10:00:00", param.Param{Name: "ts", Type: "TIMESTAMP", Value: "2024-01-01 10:00:00"}},
+		{"amount:decimal(10,2)=1.25", param.Param{Name: "amount", Type: "DECIMAL(10,2)", Value: "1.25"}},
+		{"s:varchar=", param.Param{Name: "s", Type: "VARCHAR", Value: ""}},
+		{"s:text=null", param.Param{Name: "s", Type: "VARCHAR", Value: "null"}},
+		{"id:null", param.Param{Name: "id"}},
+		{"ids:int[]=1, 2,3", param.Param{Name: "ids", Type: "INTEGER[]", Value: "[1,2,3]"}},
+		{`tags:varchar[]=["a,b","c"]`, param.Param{Name: "tags", Type: "VARCHAR[]", Value: `["a,b","c"]`}},
+	}
+	for _, c := range cases {
+		got, err := param.Parse(c.spec)
+		assert.NoError(t, err, c.spec)
+		assert.Equal(t, c.want, got, c.spec)
+	}
+
+	_, err := param.Parse("id:int=abc")
+	assert.Error(t, err)
+	// NULL is passed as id:null, not as a value
+	_, err = param.Parse("id:int=null")
+	assert.Error(t, err)
+	for _, spec := range []string{"id:int=", "d:date=", "u:uuid="} {
+		_, err = param.Parse(spec)
+		assert.ErrorContains(t, err, ":null for NULL", spec)
+	}
+	_, err = param.Parse("id:widget=1")
+	assert.Error(t, err)
+}
+
+func TestParseAllRejectsMixing(t *testing.T) {
+	_, err := param.ParseAll([]string{"1", "id=2"})
+	assert.Error(t, err)
+}
+
+func TestFromJSON(t *testing.T) {
+	params, err := param.FromJSON(strings.NewReader(`{"id:int": "7", "ids": [1, 2.5], "name": "x", "gone": null}`))
+	assert.NoError(t, err)
+	assert.Equal(t, param.Params{
+		{Name: "gone"},
+		{Name: "id", Type: "INTEGER", Value: int64(7)},
+		{Name: "ids", Type: "DOUBLE[]", Value: "[1,2.5]"},
+		{Name: "name", Value: "x"},
+	}, params)
+
+	params, err = param.FromJSON(strings.NewReader(`[1, "a"]`))
+	assert.NoError(t, err)
+	assert.Equal(t, param.Params{{Value: int64(1)}, {Value: "a"}}, params)
+}
+
+func TestBind(t *testing.T) {
+	params, err := param.ParseAll([]string{"id:int=5", "name=bob", "unused=1"})
+	assert.NoError(t, err)
+
+	statement := script.Split("select * from t where id = $id and name = $name")[0]
+	query, args, err := params.Bind(statement)
+	assert.NoError(t, err)
+	assert.Equal(t, "select * from t where id = CAST($id AS INTEGER) and name = $name", query)
+	assert.Equal(t, []interface{}{sql.Named("id", int64(5)), sql.Named("name", "bob")}, args)
+
+	_, _, err = params.Bind(script.Split("select $missing")[0])
+	assert.Error(t, err)
+
+	positional, err := param.ParseAll([]string{":int=1", "x"})
+	assert.NoError(t, err)
+	query, args, err = positional.Bind(script.Split("select ?, ?")[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "select CAST(? AS INTEGER), ?", query)
+	assert.Equal(t, []interface{}{int64(1), "x"}, args)
+}
+
+func TestRecordReaders(t *testing.T) {
+	records, err := param.NewRecordReader(strings.NewReader("{\"id\": 1}\n\n{\"id\": 2}\n"), "ndjson")
+	assert.NoError(t, err)
+
+	params, line, err := records.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, line)
+	assert.Equal(t, param.Params{{Name: "id", Value: int64(1)}}, params)
+
+	_, line, err = records.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, line)
+
+	_, _, err = records.Next()
+	assert.ErrorIs(t, err, io.EOF)
+
+	records, err = param.NewRecordReader(strings.NewReader("id:int,name\n7,bob\n"), "csv")
+	assert.NoError(t, err)
+	params, line, err = records.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, line)
+	assert.Equal(t, param.Params{{Name: "id", Type: "INTEGER", Value: int64(7)}, {Name: "name", Value: "bob"}}, params)
+}
+
+func TestRecordReadersSkipMalformedRecords(t *testing.T) {
+	records, err := param.NewRecordReader(strings.NewReader("{\"id\": 1}\nnot json\n{\"id\": 3}\n"), "ndjson")
+	assert.NoError(t, err)
+
+	_, _, err = records.Next()
+	assert.NoError(t, err)
+	_, line, err := records.Next()
+	var recordErr *param.RecordError
+	assert.ErrorAs(t, err, &recordErr)
+	assert.Equal(t, 2, line)
+	params, line, err := records.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, line)
+	assert.Equal(t, param.Params{{Name: "id", Value: int64(3)}}, params)
+
+	records, err = param.NewRecordReader(strings.NewReader("id:int,name\n7,bob,extra\nx,amy\n8,cat\n"), "csv")
+	assert.NoError(t, err)
+	_, line, err = records.Next()
+	assert.ErrorAs(t, err, &recordErr)
+	assert.Equal(t, 2, line)
+	_, line, err = records.Next()
+	assert.ErrorAs(t, err, &recordErr)
+	assert.Equal(t, 3, line)
+	params, line, err = records.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, line)
+	assert.Equal(t, param.Params{{Name: "id", Type: "INTEGER", Value: int64(8)}, {Name: "name", Value: "cat"}}, params)
+}
@@ -0,0 +1,125 @@
+package param
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// RecordReader reads one set of named parameters per input record.
+type RecordReader interface {
+	// Next returns the next record's parameters and the line it started on, or io.EOF.
+	Next() (Params, int, error)
+}
+
+// RecordError is a malformed input record. The reader has moved past it, so the next call
+// to Next reads the following record.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// InferRecordFormat picks ndjson or csv from a file extension.
+func InferRecordFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl", ".json":
+		return "ndjson", nil
+	case ".csv":
+		return "csv", nil
+	case ".tsv":
+		return "tsv", nil
+	}
+	return "", fmt.Errorf("cannot infer the record format of %q, set it with --each-format", path)
+}
+
+// NewRecordReader reads NDJSON objects or CSV/TSV rows from r. CSV headers name the
+// parameters and may carry type annotations such as id:int.
+func NewRecordReader(r io.Reader, format string) (RecordReader, error) {
+	switch strings.ToLower(format) {
+	case "ndjson", "jsonl":
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+		return &ndjsonRecords{scanner: scanner}, nil
+	case "csv", "tsv":
+		reader := csv.NewReader(r)
+		if format == "tsv" {
+			reader.Comma = '\t'
+		}
+		reader.ReuseRecord = true
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		return &csvRecords{reader: reader, header: append([]string{}, header...)}, nil
+	}
+	return nil, fmt.Errorf("unsupported record format %q (expected ndjson, csv or tsv)", format)
+}
+
+type ndjsonRecords struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonRecords) Next() (Params, int, error) {
+	for n.scanner.Scan() {
+		n.line++
+		text := strings.TrimSpace(n.scanner.Text())
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, "{") {
+			return nil, n.line, &RecordError{Line: n.line, Err: fmt.Errorf("expected a JSON object")}
+		}
+		params, err := FromJSON(strings.NewReader(text))
+		if err != nil {
+			return nil, n.line, &RecordError{Line: n.line, Err: err}
+		}
+		return params, n.line, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return nil, n.line, err
+	}
+	return nil, n.line, io.EOF
+}
+
+type csvRecords struct {
+	reader *csv.Reader
+	header []string
+}
+
+func (c *csvRecords) Next() (Params, int, error) {
+	row, err := c.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, parseErr.StartLine, &RecordError{Line: parseErr.StartLine, Err: parseErr.Err}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	line, _ := c.reader.FieldPos(0)
+
+	params := make(Params, 0, len(row))
+	for i, value := range row {
+		p, err := Parse(c.header[i] + "=" + value)
+		if err != nil {
+			return nil, line, &RecordError{Line: line, Err: err}
+		}
+		if p.Name == "" {
+			return nil, line, &RecordError{Line: line, Err: fmt.Errorf("column %q is not a valid parameter name", c.header[i])}
+		}
+		params = append(params, p)
+	}
+	return params, line, nil
+}
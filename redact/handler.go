@@ -0,0 +1,84 @@
+package redact
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// Handler is a slog.Handler that hides credentials in the message and every attribute
+// before passing records on.
+type Handler struct {
+	next slog.Handler
+}
+
+func NewHandler(next slog.Handler) *Handler {
+	return &Handler{next: next}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, String(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(Attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = Attr(a)
+	}
+	return &Handler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name)}
+}
+
+// Attr hides the credentials in an attribute's value, resolving LogValuers first.
+func Attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, String(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]any, len(group))
+		for i, ga := range group {
+			redacted[i] = Attr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		return slog.Any(a.Key, value(v.Any()))
+	default:
+		return slog.Attr{Key: a.Key, Value: v}
+	}
+}
+
+func value(v any) any {
+	switch v := v.(type) {
+	case error:
+		return Error(v)
+	case fmt.Stringer:
+		return String(v.String())
+	}
+
+	// Structs, maps and slices are redacted in the JSON they would be logged as
+	data, err := json.Marshal(v)
+	if err != nil {
+		return String(fmt.Sprintf("%+v", v))
+	}
+	redacted := []byte(String(string(data)))
+	if !json.Valid(redacted) {
+		return String(string(data))
+	}
+	return json.RawMessage(redacted)
+}
@@ -0,0 +1,112 @@
+// Package redact hides credentials in connection strings, SQL, log records and errors.
+package redact
+
+import (
+	"regexp"
+
+	"github.com/SandwichLabs/duck-tape/secret"
+)
+
+// Mask replaces every credential that is found.
+const Mask = "REDACTED"
+
+// credentialKeys are the names of the key=value pairs and options that hold credentials.
+const credentialKeys = `(?:s3_|gcs_|r2_|azure_)?(?:password|passwd|pwd|secret|secret_access_key|access_key_id|key_id|session_token|token|api_key|account_key|accountkey|client_secret|connection_string|sas_token|sharedaccesssignature)`
+
+var (
+	// uriPattern matches the password in URI user info, e.g. postgres://app:secret@db/app.
+	uriPattern = regexp.MustCompile(`(?i)\b([a-z][a-z0-9+.-]*://[^:/?#@\s'"]*):([^@/\s'"]*)@`)
+
+	// mysqlPattern matches go-sql-driver style MySQL DSNs, e.g. app:secret@tcp(db:3306)/app.
+	mysqlPattern = regexp.MustCompile(`\b([^\s:@/'"]+):([^\s@'"]+)@(tcp|udp|unix)\(`)
+
+	// keyValuePattern matches credential key=value pairs as used in libpq and DuckDB MySQL DSNs
+	// ("host=db password=secret"), URI query strings and Azure connection strings (AccountKey=...;).
+	keyValuePattern = regexp.MustCompile(`(?i)\b(` + credentialKeys + `)(\s*=\s*)('[^']*'|"[^"]*"|[^\s;&,"']+)`)
+
+	// credentialKeyPattern matches a credential key on its own, as in a storage connection's options.
+	credentialKeyPattern = regexp.MustCompile(`(?i)^(?:` + credentialKeys + `)$`)
+
+	// secretOptionPattern matches credential options in DuckDB's CREATE SECRET, e.g. SECRET 'abc'.
+	secretOptionPattern = regexp.MustCompile(`(?i)\b(key_id|secret|session_token|account_key|client_secret|connection_string|password|token)(\s+)'([^']*)'`)
+
+	// awsKeyIDPattern matches AWS access key ids wherever they appear.
+	awsKeyIDPattern = regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)
+)
+
+// String hides the credentials in s. Secret references such as ${env:PG_PASSWORD} are
+// kept, since they show where a value comes from without revealing it, but any credential
+// resolved from one in this process is hidden wherever it appears.
+func String(s string) string {
+	s = secret.Mask(s, Mask)
+	s = uriPattern.ReplaceAllStringFunc(s, func(match string) string {
+		return keep(uriPattern, match, "${1}:"+Mask+"@", 2)
+	})
+	s = mysqlPattern.ReplaceAllString(s, "${1}:"+Mask+"@${3}(")
+	s = keyValuePattern.ReplaceAllStringFunc(s, func(match string) string {
+		return keep(keyValuePattern, match, "${1}${2}"+Mask, 3)
+	})
+	s = secretOptionPattern.ReplaceAllStringFunc(s, func(match string) string {
+		return keep(secretOptionPattern, match, "${1}${2}'"+Mask+"'", 3)
+	})
+	return awsKeyIDPattern.ReplaceAllString(s, Mask)
+}
+
+// CredentialKey reports whether an option named key holds a credential, e.g. secret or key_id.
+func CredentialKey(key string) bool {
+	return credentialKeyPattern.MatchString(key)
+}
+
+// CredentialReferences returns the secret references in s that stand for a credential, such
+// as ${env:PG_PASSWORD} in password=${env:PG_PASSWORD} or postgres://app:${secret:pg}@db.
+func CredentialReferences(s string) map[string]bool {
+	references := map[string]bool{}
+	for _, credential := range []struct {
+		pattern *regexp.Regexp
+		group   int
+	}{{uriPattern, 2}, {mysqlPattern, 2}, {keyValuePattern, 3}, {secretOptionPattern, 3}} {
+		for _, groups := range credential.pattern.FindAllStringSubmatch(s, -1) {
+			for _, reference := range secret.References(groups[credential.group]) {
+				references[reference] = true
+			}
+		}
+	}
+	return references
+}
+
+// keep expands template for match unless the credential group is a secret reference.
+func keep(pattern *regexp.Regexp, match string, template string, group int) string {
+	groups := pattern.FindStringSubmatchIndex(match)
+	if secret.HasReferences(match[groups[2*group]:groups[2*group+1]]) {
+		return match
+	}
+	return string(pattern.ExpandString(nil, template, match, groups))
+}
+
+// redactedError keeps the original error for errors.Is and errors.As while hiding
+// credentials in its message.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Error returns err with the credentials in its message hidden. The original error is
+// still reachable through errors.Is and errors.As.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := String(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright © 2024 Zac Orndorff zac@orndorff.dev
+*/
+package savedquery
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/SandwichLabs/duck-tape/script"
+	"github.com/spf13/viper"
+)
+
+type SavedQuery struct {
+	Name        string   `yaml:"name"`
+	SQL         string   `yaml:"sql"`
+	Connections []string `yaml:"connections"` // Connections attached whenever the query runs
+	Params      []string `yaml:"params"`      // Named $parameters the query expects
+}
+
+func (q SavedQuery) String() string {
+	return fmt.Sprintf("SavedQuery{Name: %s, Connections: %v, Params: %v}", q.Name, q.Connections, q.Params)
+}
+
+// Script renders the query as a runnable SQL file, with its connections and
+// parameters noted in a leading comment.
+func (q SavedQuery) Script() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("-- name: %s\n", q.Name))
+	if len(q.Connections) > 0 {
+		sb.WriteString(fmt.Sprintf("-- connections: %s\n", strings.Join(q.Connections, ", ")))
+	}
+	if len(q.Params) > 0 {
+		sb.WriteString(fmt.Sprintf("-- params: %s\n", strings.Join(q.Params, ", ")))
+	}
+	sb.WriteString(strings.TrimSpace(q.SQL))
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// New builds a saved query, picking up the $name parameters used in sql.
+func New(name string, sql string, connections []string) SavedQuery {
+	return SavedQuery{
+		Name:        name,
+		SQL:         sql,
+		Connections: connections,
+		Params:      script.ParamNames(sql),
+	}
+}
+
+func SavedQueryFromViper(v *viper.Viper) SavedQuery {
+	return SavedQuery{
+		Name:        v.GetString("name"),
+		SQL:         v.GetString("sql"),
+		Connections: v.GetStringSlice("connections"),
+		Params:      v.GetStringSlice("params"),
+	}
+}
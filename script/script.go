@@ -0,0 +1,279 @@
+/*
+Copyright © 2024 Zac Orndorff zac@orndorff.dev
+*/
+package script
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Statement is a single SQL statement extracted from a script.
+type Statement struct {
+	SQL string
+	// Line is the 1-based line in the script where the statement starts.
+	Line int
+	// HasParams reports whether the statement contains ? or $ placeholders.
+	HasParams bool
+	// ParamNames lists the distinct $name placeholders in order of first use.
+	ParamNames []string
+}
+
+// Placeholder is a parameter reference found in a statement.
+type Placeholder struct {
+	// Name is set for $name placeholders.
+	Name string
+	// Position is the 1-based position for ? (counted in order) and $1 style placeholders.
+	Position int
+}
+
+type tokenKind int
+
+const (
+	tokenText tokenKind = iota
+	tokenQuoted
+	tokenComment
+	tokenPlaceholder
+	tokenSemicolon
+)
+
+type token struct {
+	kind       tokenKind
+	start, end int
+}
+
+// Split breaks a SQL script into statements on top-level semicolons.
+// Semicolons inside quoted strings, quoted identifiers, dollar-quoted strings
+// and comments are ignored. A leading #! line is skipped so SQL files can be
+// executable scripts.
+func Split(src string) []Statement {
+	src = stripShebang(src)
+
+	var statements []Statement
+	var current strings.Builder
+	line, startLine := 1, 0
+	hasParams := false
+	var paramNames []string
+
+	flush := func() {
+		// Statements made up only of comments are dropped
+		if startLine != 0 {
+			sql := strings.TrimSpace(current.String())
+			statements = append(statements, Statement{SQL: sql, Line: startLine, HasParams: hasParams, ParamNames: paramNames})
+		}
+		current.Reset()
+		startLine = 0
+		hasParams = false
+		paramNames = nil
+	}
+
+	scan(src, func(t token) {
+		text := src[t.start:t.end]
+		switch t.kind {
+		case tokenSemicolon:
+			flush()
+		case tokenComment:
+			// Comments ahead of a statement are dropped rather than kept as part of it
+			if startLine != 0 {
+				current.WriteString(text)
+			}
+		default:
+			if startLine == 0 && strings.TrimSpace(text) != "" {
+				startLine = line
+			}
+			if t.kind == tokenPlaceholder {
+				hasParams = true
+				if name := strings.TrimPrefix(text, "$"); text[0] == '$' && !isDigit(name[0]) && !slices.Contains(paramNames, name) {
+					paramNames = append(paramNames, name)
+				}
+			}
+			current.WriteString(text)
+		}
+		line += strings.Count(text, "\n")
+	})
+	flush()
+
+	return statements
+}
+
+// ParamNames returns the distinct $name placeholders used across every statement in src.
+func ParamNames(src string) []string {
+	var names []string
+	for _, statement := range Split(src) {
+		for _, name := range statement.ParamNames {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// RewritePlaceholders returns sql with every placeholder replaced by the result of replace,
+// which receives the placeholder and its original text. Placeholders inside strings and
+// comments are left alone.
+func RewritePlaceholders(sql string, replace func(p Placeholder, text string) string) string {
+	var out strings.Builder
+	position := 0
+	scan(sql, func(t token) {
+		text := sql[t.start:t.end]
+		if t.kind != tokenPlaceholder {
+			out.WriteString(text)
+			return
+		}
+
+		var p Placeholder
+		switch {
+		case text == "?":
+			position++
+			p.Position = position
+		case isDigit(text[1]):
+			p.Position, _ = strconv.Atoi(text[1:])
+		default:
+			p.Name = text[1:]
+		}
+		out.WriteString(replace(p, text))
+	})
+	return out.String()
+}
+
+// Words returns the identifiers and string literals in sql, skipping comments. Quoted
+// identifiers are returned unquoted with the identifiers.
+func Words(sql string) (identifiers []string, literals []string) {
+	identEnd := -1
+	scan(sql, func(t token) {
+		text := sql[t.start:t.end]
+		switch {
+		case t.kind == tokenText && isIdentChar(text[0]):
+			// Text tokens are single characters, so identifiers are assembled here
+			if t.start == identEnd {
+				identifiers[len(identifiers)-1] += text
+			} else {
+				identifiers = append(identifiers, text)
+			}
+			identEnd = t.end
+		case t.kind == tokenQuoted && text[0] == '"':
+			identifiers = append(identifiers, unquote(text, '"'))
+		case t.kind == tokenQuoted && text[0] == '\'':
+			literals = append(literals, unquote(text, '\''))
+		case t.kind == tokenQuoted:
+			// Dollar quoted, $tag$...$tag$
+			tag, _ := dollarTag(text)
+			literals = append(literals, strings.TrimSuffix(strings.TrimPrefix(text, tag), tag))
+		}
+	})
+	return identifiers, literals
+}
+
+// unquote strips the quotes around a quoted token and undoes doubled quote escapes.
+func unquote(text string, quote byte) string {
+	q := string(quote)
+	text = strings.TrimPrefix(text, q)
+	if len(text) > 0 && strings.HasSuffix(text, q) {
+		text = text[:len(text)-1]
+	}
+	return strings.ReplaceAll(text, q+q, q)
+}
+
+// scan tokenizes src just enough to tell statement text apart from strings,
+// comments and placeholders.
+func scan(src string, visit func(token)) {
+	for i := 0; i < len(src); {
+		c := src[i]
+		end := i + 1
+		kind := tokenText
+
+		switch {
+		case c == '\'' || c == '"':
+			kind, end = tokenQuoted, closingQuote(src, i, c)
+		case c == '-' && i+1 < len(src) && src[i+1] == '-':
+			kind, end = tokenComment, len(src)
+			if nl := strings.IndexByte(src[i:], '\n'); nl >= 0 {
+				end = i + nl
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			kind, end = tokenComment, len(src)
+			if close := strings.Index(src[i+2:], "*/"); close >= 0 {
+				end = i + 2 + close + 2
+			}
+		case c == '$':
+			if tag, ok := dollarTag(src[i:]); ok {
+				kind, end = tokenQuoted, len(src)
+				if close := strings.Index(src[i+len(tag):], tag); close >= 0 {
+					end = i + len(tag) + close + len(tag)
+				}
+			} else if i+1 < len(src) && isIdentChar(src[i+1]) {
+				kind, end = tokenPlaceholder, i+1+len(identAt(src, i+1))
+			}
+		case c == '?':
+			kind = tokenPlaceholder
+		case c == ';':
+			kind = tokenSemicolon
+		}
+
+		visit(token{kind: kind, start: i, end: end})
+		i = end
+	}
+}
+
+func stripShebang(src string) string {
+	if !strings.HasPrefix(src, "#!") {
+		return src
+	}
+	// Keep the newline so that line numbers still match the file
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		return src[i:]
+	}
+	return ""
+}
+
+// closingQuote returns the index just past the quote that closes the one at start.
+// Doubled quotes are treated as escapes.
+func closingQuote(src string, start int, quote byte) int {
+	for i := start + 1; i < len(src); i++ {
+		if src[i] != quote {
+			continue
+		}
+		if i+1 < len(src) && src[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(src)
+}
+
+// dollarTag recognises the opening tag of a dollar-quoted string such as $$ or $body$.
+func dollarTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '$' {
+			tag := s[:i+1]
+			// $1 is a positional parameter, not a tag
+			if i > 1 && isDigit(s[1]) {
+				return "", false
+			}
+			return tag, true
+		}
+		if !isIdentChar(s[i]) {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func identAt(s string, start int) string {
+	end := start
+	for end < len(s) && isIdentChar(s[end]) {
+		end++
+	}
+	return s[start:end]
+}
@@ -0,0 +1,69 @@
+package script_test
+
+import (
+	"testing"
+
+	"github.com/SandwichLabs/duck-tape/script"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	src := `#!/usr/bin/env -S dt run
+-- create the table
+create table t (id int, name text);
+
+insert into t values (1, 'semi;colon'), (2, 'it''s');
+/* multi
+   line; comment */
+select "odd;name", $$body; here$$
+from t
+where id = ?;
+-- trailing comment only
+`
+	statements := script.Split(src)
+	assert.Len(t, statements, 3)
+
+	assert.Equal(t, "create table t (id int, name text)", statements[0].SQL)
+	assert.Equal(t, 3, statements[0].Line)
+	assert.False(t, statements[0].HasParams)
+
+	assert.Equal(t, "insert into t values (1, 'semi;colon'), (2, 'it''s')", statements[1].SQL)
+	assert.Equal(t, 5, statements[1].Line)
+
+	assert.Equal(t, 8, statements[2].Line)
+	assert.True(t, statements[2].HasParams)
+	assert.Contains(t, statements[2].SQL, "$$body; here$$")
+}
+
+func TestSplitNamedAndPositionalParams(t *testing.T) {
+	statements := script.Split("select $1; select $name; select '$x'")
+	assert.Len(t, statements, 3)
+	assert.True(t, statements[0].HasParams)
+	assert.True(t, statements[1].HasParams)
+	assert.False(t, statements[2].HasParams)
+}
+
+func TestParamNames(t *testing.T) {
+	names := script.ParamNames("select $id, $since, $1; select * from t where id = $id and name = '$name'")
+	assert.Equal(t, []string{"id", "since"}, names)
+}
+
+func TestRewritePlaceholders(t *testing.T) {
+	sql := script.RewritePlaceholders("select ?, $id, $2, '?', ? -- $id", func(p script.Placeholder, text string) string {
+		switch {
+		case p.Name == "id":
+			return "CAST($id AS INTEGER)"
+		case p.Position == 2:
+			return "CAST(" + text + " AS DATE)"
+		}
+		return text
+	})
+	assert.Equal(t, "select ?, CAST($id AS INTEGER), CAST($2 AS DATE), '?', CAST(? AS DATE) -- $id", sql)
+}
+
+func TestWords(t *testing.T) {
+	identifiers, literals := script.Words(`-- from hidden.table
+insert into pg.public."Users" select * from 's3://bucket/x.parquet' where name = 'it''s' and id = $id`)
+	assert.Equal(t, []string{"insert", "into", "pg", "public", "Users", "select", "from", "where", "name", "and", "id"}, identifiers)
+	assert.Equal(t, []string{"s3://bucket/x.parquet", "it's"}, literals)
+}
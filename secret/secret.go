@@ -0,0 +1,172 @@
+// Package secret resolves secret references such as ${env:PG_PASSWORD} in connection
+// strings, so that credentials don't have to be stored in the config file.
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Provider looks up the value of a reference for one scheme. For ${file:/run/secrets/pg}
+// the "file" provider is called with "/run/secrets/pg".
+type Provider interface {
+	Resolve(ref string) (string, error)
+}
+
+// ProviderFunc adapts a function to a Provider.
+type ProviderFunc func(ref string) (string, error)
+
+func (f ProviderFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		"env":     ProviderFunc(resolveEnv),
+		"file":    ProviderFunc(resolveFile),
+		"cmd":     ProviderFunc(resolveCmd),
+		"keyring": ProviderFunc(resolveKeyring),
+	}
+	// resolved holds the credentials handed out by ExpandHiding so that Mask can hide them.
+	resolved = map[string]struct{}{}
+)
+
+// Register adds or replaces the provider for a scheme.
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = p
+}
+
+// Schemes returns the registered schemes in sorted order.
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	schemes := make([]string, 0, len(providers))
+	for scheme := range providers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// referencePattern matches ${scheme:ref}. The reference runs to the first closing brace.
+var referencePattern = regexp.MustCompile(`\$\{([a-z][a-z0-9_-]*):([^}]*)\}`)
+
+// HasReferences reports whether s contains any secret references.
+func HasReferences(s string) bool {
+	return referencePattern.MatchString(s)
+}
+
+// References returns the secret references in s, such as ${env:PG_PASSWORD}.
+func References(s string) []string {
+	return referencePattern.FindAllString(s, -1)
+}
+
+// Expand replaces every secret reference in s with its resolved value.
+func Expand(s string) (string, error) {
+	return ExpandHiding(s, nil)
+}
+
+// ExpandHiding is Expand, but Mask hides the values of the references hide reports as
+// credentials from then on. Other values, such as a region or a host name, stay visible.
+func ExpandHiding(s string, hide func(reference string) bool) (string, error) {
+	var firstErr error
+	expanded := referencePattern.ReplaceAllStringFunc(s, func(match string) string {
+		if firstErr != nil {
+			return match
+		}
+		groups := referencePattern.FindStringSubmatch(match)
+		value, err := resolve(groups[1], groups[2])
+		if err != nil {
+			firstErr = fmt.Errorf("resolving %s: %w", match, err)
+			return match
+		}
+		if value != "" && hide != nil && hide(match) {
+			mu.Lock()
+			resolved[value] = struct{}{}
+			mu.Unlock()
+		}
+		return value
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return expanded, nil
+}
+
+func resolve(scheme string, ref string) (string, error) {
+	mu.RLock()
+	p, ok := providers[scheme]
+	mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider %q (expected one of %s)", scheme, strings.Join(Schemes(), ", "))
+	}
+	return p.Resolve(ref)
+}
+
+// Mask replaces any credential resolved by ExpandHiding in this process with mask, so that
+// resolved credentials can't leak through logs or error messages.
+func Mask(s string, mask string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for value := range resolved {
+		s = strings.ReplaceAll(s, value, mask)
+	}
+	return s
+}
+
+func resolveEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+func resolveFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func resolveCmd(command string) (string, error) {
+	return run(exec.Command("sh", "-c", command))
+}
+
+// resolveKeyring reads a password stored under the "dt" service in the OS keyring,
+// using the macOS keychain or the freedesktop secret service (secret-tool).
+func resolveKeyring(account string) (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return run(exec.Command("security", "find-generic-password", "-s", "dt", "-a", account, "-w"))
+	case "linux", "freebsd", "openbsd":
+		return run(exec.Command("secret-tool", "lookup", "service", "dt", "account", account))
+	default:
+		return "", fmt.Errorf("the keyring provider is not supported on %s", runtime.GOOS)
+	}
+}
+
+// run executes cmd and returns its stdout without the trailing newline.
+func run(cmd *exec.Cmd) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+		}
+		return "", fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
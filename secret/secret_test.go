@@ -0,0 +1,113 @@
+package secret_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SandwichLabs/duck-tape/secret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("DT_TEST_PASSWORD", "hunter2")
+	path := filepath.Join(t.TempDir(), "pg")
+	require.NoError(t, os.WriteFile(path, []byte("s3cret\n"), 0600))
+
+	expanded, err := secret.Expand("host=db password=${env:DT_TEST_PASSWORD}")
+	assert.NoError(t, err)
+	assert.Equal(t, "host=db password=hunter2", expanded)
+
+	expanded, err = secret.Expand("password=${file:" + path + "} token=${cmd:echo abc}")
+	assert.NoError(t, err)
+	assert.Equal(t, "password=s3cret token=abc", expanded)
+
+	expanded, err = secret.Expand("/data/app.sqlite")
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/app.sqlite", expanded)
+
+	_, err = secret.Expand("password=${env:DT_TEST_UNSET}")
+	assert.ErrorContains(t, err, "DT_TEST_UNSET is not set")
+
+	_, err = secret.Expand("password=${vault:pg}")
+	assert.ErrorContains(t, err, `unknown secret provider "vault"`)
+}
+
+func TestRegisterAndMask(t *testing.T) {
+	secret.Register("test", secret.ProviderFunc(func(ref string) (string, error) {
+		return "value-of-" + ref, nil
+	}))
+
+	expanded, err := secret.Expand("${test:region}")
+	assert.NoError(t, err)
+	assert.Equal(t, "value-of-region", expanded)
+
+	// Only the credentials are masked, other resolved values stay readable
+	expanded, err = secret.ExpandHiding("${test:pg} in ${test:host}", func(reference string) bool {
+		return reference == "${test:pg}"
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "value-of-pg in value-of-host", expanded)
+	assert.Equal(t, "error near REDACTED at value-of-host in value-of-region",
+		secret.Mask("error near value-of-pg at value-of-host in value-of-region", "REDACTED"))
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store := &secret.Store{Path: path, Passphrase: "correct horse"}
+
+	names, err := store.Names()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	require.NoError(t, store.Set("pg", "hunter2"))
+	require.NoError(t, store.Set("mysql", "s3cret"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+
+	value, err := store.Resolve("pg")
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	names, err = store.Names()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mysql", "pg"}, names)
+
+	require.NoError(t, store.Delete("mysql"))
+	assert.Error(t, store.Delete("mysql"))
+
+	wrong := &secret.Store{Path: path, Passphrase: "wrong"}
+	_, err = wrong.Resolve("pg")
+	assert.ErrorContains(t, err, "wrong passphrase")
+
+	_, err = (&secret.Store{Path: path}).Resolve("pg")
+	assert.ErrorContains(t, err, secret.PassphraseEnv)
+}
+
+func TestStoreDerivesKeyOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	require.NoError(t, (&secret.Store{Path: path, Passphrase: "correct horse"}).Set("pg", "hunter2"))
+
+	store := &secret.Store{Path: path, Passphrase: "correct horse"}
+	start := time.Now()
+	_, err := store.Resolve("pg")
+	require.NoError(t, err)
+	derive := time.Since(start)
+
+	// Every later reference reuses the key
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		value, err := store.Resolve("pg")
+		require.NoError(t, err)
+		assert.Equal(t, "hunter2", value)
+	}
+	assert.Less(t, time.Since(start), 3*derive)
+
+	store.Passphrase = "wrong"
+	_, err = store.Resolve("pg")
+	assert.ErrorContains(t, err, "wrong passphrase")
+}
@@ -0,0 +1,202 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// PassphraseEnv is the environment variable holding the passphrase for the encrypted store.
+const PassphraseEnv = "DT_SECRETS_PASSPHRASE"
+
+const (
+	storeVersion    = 1
+	storeIterations = 600_000
+)
+
+// Store is a file of named secrets encrypted with AES-256-GCM under a key derived
+// from a passphrase. The file is safe to commit alongside the config; the passphrase
+// is shared separately. It resolves ${secret:name} references.
+type Store struct {
+	Path       string
+	Passphrase string
+
+	// Deriving the key is deliberately slow, so the cipher for the last salt is kept for
+	// the other references resolved by the process.
+	mu        sync.Mutex
+	aead      cipher.AEAD
+	aeadParam string
+}
+
+// storeFile is the on-disk format. Salt and nonce are regenerated on every save.
+type storeFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewStore returns a store at path using the passphrase from DT_SECRETS_PASSPHRASE.
+func NewStore(path string) *Store {
+	return &Store{Path: path, Passphrase: os.Getenv(PassphraseEnv)}
+}
+
+func (s *Store) Resolve(name string) (string, error) {
+	secrets, err := s.Load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s not found in %s", name, s.Path)
+	}
+	return value, nil
+}
+
+// Load decrypts every secret in the store. A missing file is an empty store.
+func (s *Store) Load() (map[string]string, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("reading secret store %s: %w", s.Path, err)
+	}
+	if file.Version != storeVersion {
+		return nil, fmt.Errorf("secret store %s has unsupported version %d", s.Path, file.Version)
+	}
+
+	gcm, err := s.cipher(file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting secret store %s: wrong passphrase or corrupted file", s.Path)
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("reading secret store %s: %w", s.Path, err)
+	}
+	return secrets, nil
+}
+
+// Save encrypts secrets and replaces the store file.
+func (s *Store) Save(secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	file := storeFile{
+		Version:    storeVersion,
+		KDF:        "pbkdf2-sha256",
+		Iterations: storeIterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+
+	gcm, err := s.cipher(file.Salt, file.Iterations)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.Path, append(data, '\n'), 0600)
+}
+
+// Set stores a secret, replacing any existing value.
+func (s *Store) Set(name string, value string) error {
+	secrets, err := s.Load()
+	if err != nil {
+		return err
+	}
+	secrets[name] = value
+	return s.Save(secrets)
+}
+
+// Delete removes a secret, returning an error if it doesn't exist.
+func (s *Store) Delete(name string) error {
+	secrets, err := s.Load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[name]; !ok {
+		return fmt.Errorf("secret %s not found in %s", name, s.Path)
+	}
+	delete(secrets, name)
+	return s.Save(secrets)
+}
+
+// Names returns the names of the stored secrets in sorted order.
+func (s *Store) Names() ([]string, error) {
+	secrets, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Store) cipher(salt []byte, iterations int) (cipher.AEAD, error) {
+	if s.Passphrase == "" {
+		return nil, fmt.Errorf("set %s to use the secret store", PassphraseEnv)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	param := fmt.Sprintf("%x:%x:%d", sha256.Sum256([]byte(s.Passphrase)), salt, iterations)
+	if s.aead != nil && s.aeadParam == param {
+		return s.aead, nil
+	}
+
+	key, err := pbkdf2.Key(sha256.New, s.Passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s.aead, s.aeadParam = gcm, param
+	return gcm, nil
+}
@@ -0,0 +1,340 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectFileNames are the files that define a project workspace, looked for in the
+// working directory and each of its parents.
+var ProjectFileNames = []string{".dt.yaml", "dt.yaml"}
+
+// projectSettings are the dt settings a project file may set besides its workspace.
+var projectSettings = []string{"on_connection_error", "secrets_file", "log_level", "lock_timeout"}
+
+// Project is a workspace defined by a file checked in with a project. The file holds
+// the same settings as a workspace in the user config, with an optional workspace name
+// and a dbLocation relative to the project folder:
+//
+//	workspace: analytics
+//	dbLocation: data/analytics.duckdb
+//	connections:
+//	  pg:
+//	    name: pg
+//	    type: POSTGRES
+//	    conn_string: host=db password=${env:PG_PASSWORD}
+//	on_connection_error: skip
+type Project struct {
+	Path string
+	// Workspace is the workspace name, the project folder's name unless the file sets one.
+	Workspace string
+	// Settings are the workspace's settings, with dbLocation made absolute.
+	Settings map[string]interface{}
+	// Globals are the dt settings the file sets, such as on_connection_error.
+	Globals map[string]interface{}
+	// Hash is the SHA-256 of the file's content, for ProjectTrust.
+	Hash string
+	// Trust, when set, is updated whenever dt saves changes to the file, so that dt's own
+	// edits don't make the project untrusted.
+	Trust *ProjectTrust
+}
+
+var activeProject *Project
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// Dir is the project folder.
+func (p *Project) Dir() string {
+	return filepath.Dir(p.Path)
+}
+
+// FindProject looks for a project file in dir and then each parent folder.
+func FindProject(dir string) (string, bool) {
+	for {
+		for _, name := range ProjectFileNames {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, true
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// LoadProject reads a project file.
+func LoadProject(path string) (*Project, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("reading project file %s: %w", path, err)
+	}
+
+	p := &Project{Path: path, Settings: map[string]interface{}{}, Globals: map[string]interface{}{}, Hash: contentHash(data)}
+	for key, value := range raw {
+		// Viper keys are case insensitive
+		key = strings.ToLower(key)
+		switch {
+		case key == "workspace":
+			p.Workspace, _ = value.(string)
+		case slices.Contains(projectSettings, key):
+			p.Globals[key] = value
+		default:
+			p.Settings[key] = value
+		}
+	}
+
+	if p.Workspace == "" {
+		p.Workspace = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(filepath.Base(p.Dir())), "-"), "-_")
+	}
+	if err := ValidateName(p.Workspace); err != nil {
+		return nil, fmt.Errorf("project file %s: %w", path, err)
+	}
+
+	if db, ok := p.Settings["dblocation"].(string); ok && db != "" && !filepath.IsAbs(db) {
+		p.Settings["dblocation"] = filepath.Join(p.Dir(), db)
+	}
+	return p, nil
+}
+
+// UseProject makes the project's workspace the active one. Its settings are merged over
+// any workspace of the same name in the user config, and its dt settings over the user's.
+// Changes to the workspace are saved to the project file from then on. UseProject(nil)
+// saves changes to the user config again.
+func UseProject(p *Project) error {
+	if p == nil {
+		activeProject = nil
+		return nil
+	}
+
+	merged := map[string]interface{}{p.Workspace: p.Settings}
+	for key, value := range p.Globals {
+		merged[key] = value
+	}
+	if err := viper.MergeConfigMap(merged); err != nil {
+		return err
+	}
+	viper.Set("workspace", p.Workspace)
+	activeProject = p
+	return nil
+}
+
+// ActiveProject returns the project in use, or nil outside a project.
+func ActiveProject() *Project {
+	return activeProject
+}
+
+// writeConfig saves the configuration. In a project the project's workspace and settings
+// are written to the project file, and the user config keeps its own values for them.
+func writeConfig() error {
+	if activeProject == nil {
+		return viper.WriteConfig()
+	}
+	return activeProject.write()
+}
+
+func (p *Project) write() error {
+	settings := viper.AllSettings()
+
+	user := viper.New()
+	user.SetConfigFile(viper.ConfigFileUsed())
+	if err := user.ReadInConfig(); err != nil {
+		return err
+	}
+	onDisk := user.AllSettings()
+
+	project := map[string]interface{}{"workspace": p.Workspace}
+	changed := map[string]interface{}{}
+	if block, ok := settings[p.Workspace].(map[string]interface{}); ok {
+		// Settings that come from the user's workspace of the same name stay there
+		userBlock, _ := onDisk[p.Workspace].(map[string]interface{})
+		changed = projectChanges(block, userBlock, p.Settings)
+		if _, set := p.Settings["dblocation"]; !set {
+			// The default location is in the user's dt folder, not something to share
+			delete(changed, "dblocation")
+		}
+		for key, value := range changed {
+			project[key] = value
+		}
+	}
+	if db, ok := project["dblocation"].(string); ok {
+		if rel, err := filepath.Rel(p.Dir(), db); err == nil && !strings.HasPrefix(rel, "..") {
+			project["dblocation"] = rel
+		}
+	}
+	for key := range p.Globals {
+		project[key] = settings[key]
+	}
+
+	owned := []string{p.Workspace, "workspace"}
+	for key := range p.Globals {
+		owned = append(owned, key)
+	}
+	for _, key := range owned {
+		if value, ok := onDisk[key]; ok {
+			settings[key] = value
+		} else {
+			delete(settings, key)
+		}
+	}
+
+	if err := writeYAML(p.Path, project); err != nil {
+		return err
+	}
+	p.Settings = changed
+	if p.Trust != nil {
+		data, err := os.ReadFile(p.Path)
+		if err != nil {
+			return err
+		}
+		p.Hash = contentHash(data)
+		if err := p.Trust.Trust(p); err != nil {
+			return err
+		}
+	}
+	return writeYAML(viper.ConfigFileUsed(), settings)
+}
+
+// projectChanges returns the settings of the merged workspace block that belong in the
+// project file: those the project sets itself, and those that differ from the user's
+// workspace of the same name.
+func projectChanges(merged map[string]interface{}, user map[string]interface{}, own map[string]interface{}) map[string]interface{} {
+	changes := map[string]interface{}{}
+	for key, value := range merged {
+		ownValue, inOwn := lookupKey(own, key)
+		userValue, inUser := user[key]
+
+		nested, isMap := value.(map[string]interface{})
+		userNested, userIsMap := userValue.(map[string]interface{})
+		if isMap && userIsMap {
+			ownNested, _ := ownValue.(map[string]interface{})
+			if sub := projectChanges(nested, userNested, ownNested); len(sub) > 0 || inOwn {
+				changes[key] = sub
+			}
+			continue
+		}
+		if inOwn || !inUser || !reflect.DeepEqual(value, userValue) {
+			changes[key] = value
+		}
+	}
+	return changes
+}
+
+// lookupKey finds key in settings ignoring case, as viper does.
+func lookupKey(settings map[string]interface{}, key string) (interface{}, bool) {
+	for k, value := range settings {
+		if strings.EqualFold(k, key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func writeYAML(path string, settings map[string]interface{}) error {
+	encoded, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, encoded, 0644)
+}
+
+// ErrProjectUntrusted and ErrProjectChanged are returned by ProjectTrust.Check.
+var (
+	ErrProjectUntrusted = errors.New("project file isn't trusted")
+	ErrProjectChanged   = errors.New("project file changed since it was trusted")
+)
+
+// ProjectTrust is the file recording which project files the user has trusted, by path
+// and content hash. A project file can run shell commands through ${cmd:...} references
+// and SQL through its boot settings and macros, so one found in a checkout is only used
+// once trusted, and again only after any change made outside dt is trusted.
+type ProjectTrust struct {
+	Path string
+}
+
+func (t ProjectTrust) load() (map[string]string, error) {
+	trusted := map[string]string{}
+	data, err := os.ReadFile(t.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return trusted, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &trusted); err != nil {
+		return nil, fmt.Errorf("reading trusted projects %s: %w", t.Path, err)
+	}
+	return trusted, nil
+}
+
+func (t ProjectTrust) save(trusted map[string]string) error {
+	encoded, err := yaml.Marshal(trusted)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.Path, encoded, 0600)
+}
+
+// Check returns nil if the project file is trusted with its current content.
+func (t ProjectTrust) Check(p *Project) error {
+	trusted, err := t.load()
+	if err != nil {
+		return err
+	}
+	hash, ok := trusted[p.Path]
+	switch {
+	case !ok:
+		return ErrProjectUntrusted
+	case hash != p.Hash:
+		return ErrProjectChanged
+	}
+	return nil
+}
+
+// Trust records the project file's current content as trusted.
+func (t ProjectTrust) Trust(p *Project) error {
+	trusted, err := t.load()
+	if err != nil {
+		return err
+	}
+	trusted[p.Path] = p.Hash
+	return t.save(trusted)
+}
+
+// Untrust forgets a project file, given its absolute path.
+func (t ProjectTrust) Untrust(path string) error {
+	trusted, err := t.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := trusted[path]; !ok {
+		return fmt.Errorf("%s: %w", path, ErrProjectUntrusted)
+	}
+	delete(trusted, path)
+	return t.save(trusted)
+}
+
+func contentHash(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
@@ -4,21 +4,75 @@ Copyright © Zac Orndorff zac@orndorff.dev
 package workspace
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
 	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/SandwichLabs/duck-tape/savedquery"
 	"github.com/spf13/viper"
-	"log/slog"
+	"gopkg.in/yaml.v3"
 )
 
 func getWorkspaceConnectionKey(workspace string, connectionName string) string {
 	return fmt.Sprintf("%s.connections.%s", workspace, connectionName)
 }
 
+func getWorkspaceQueryKey(workspace string, queryName string) string {
+	return fmt.Sprintf("%s.queries.%s", workspace, queryName)
+}
+
+func getWorkspaceDefaultConnectionsKey(workspace string) string {
+	return fmt.Sprintf("%s.default_connections", workspace)
+}
+
+// unsetKey removes a nested key from the loaded configuration. Viper has no delete, so the
+// configuration is read in again without the key. That replaces only the config layer, and
+// the flag bindings, environment and defaults stay as they were.
+func unsetKey(key string) error {
+	settings := viper.AllSettings()
+	parts := strings.Split(strings.ToLower(key), ".")
+
+	parent := settings
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := parent[part].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s not found in config", key)
+		}
+		parent = child
+	}
+	if _, ok := parent[parts[len(parts)-1]]; !ok {
+		return fmt.Errorf("%s not found in config", key)
+	}
+	delete(parent, parts[len(parts)-1])
+
+	encoded, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if err := viper.ReadConfig(bytes.NewReader(encoded)); err != nil {
+		return err
+	}
+	// A value set with viper.Set takes precedence over the config, so it would be read, and
+	// saved, again. Setting the parent without the key replaces any such value; a top level
+	// key is set to nil, which viper skips.
+	if len(parts) == 1 {
+		viper.Set(key, nil)
+	} else {
+		viper.Set(strings.Join(parts[:len(parts)-1], "."), parent)
+	}
+	return nil
+}
+
 func SetWorkspaceDb(workspace string, name string, save bool) (ok bool, err error) {
 	viper.Set(fmt.Sprintf("%s.dbLocation", workspace), name)
 	if save {
-		err := viper.WriteConfig()
+		err := writeConfig()
 		if err != nil {
 			slog.Error("error writing config", "error", err)
 			return false, errors.New("error saving workspace db location")
@@ -30,7 +84,7 @@ func SetWorkspaceDb(workspace string, name string, save bool) (ok bool, err erro
 func SetWorkspaceConnection(workspace string, connection connection.ConnectionConfig, save bool) (ok bool, err error) {
 	viper.Set(getWorkspaceConnectionKey(workspace, connection.Name), connection)
 	if save {
-		err := viper.WriteConfig()
+		err := writeConfig()
 		if err != nil {
 			slog.Error("SetWorkSpaceConnection Error", "Error", err)
 			return false, errors.New("error setting workspace connection")
@@ -42,18 +96,278 @@ func SetWorkspaceConnection(workspace string, connection connection.ConnectionCo
 func WorkspaceConnection(workspace string, connectionName string) (connection.ConnectionConfig, error) {
 	configConn := viper.Sub(getWorkspaceConnectionKey(workspace, connectionName))
 	if configConn == nil {
-		return connection.ConnectionConfig{}, errors.New("connection not found in workspace")
+		return connection.ConnectionConfig{}, fmt.Errorf("connection %q not found in workspace", connectionName)
 	}
 	return connection.ConnectionFromViper(configConn), nil
 }
 
+// ListWorkspaceConnections returns the names of the connections in a workspace, sorted.
 func ListWorkspaceConnections(workspace string) ([]string, error) {
-	workspaceConnections := viper.Sub(fmt.Sprintf("%s.connections", workspace))
+	workspaceConnections := viper.GetStringMap(fmt.Sprintf("%s.connections", workspace))
+
+	names := make([]string, 0, len(workspaceConnections))
+	for name := range workspaceConnections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func DeleteWorkspaceConnection(workspace string, connectionName string, save bool) (ok bool, err error) {
+	if _, err := WorkspaceConnection(workspace, connectionName); err != nil {
+		return false, err
+	}
+	if err := unsetKey(getWorkspaceConnectionKey(workspace, connectionName)); err != nil {
+		slog.Error("DeleteWorkspaceConnection Error", "Error", err)
+		return false, errors.New("error removing workspace connection")
+	}
+	if defaults := WorkspaceDefaultConnections(workspace); slices.Contains(defaults, connectionName) {
+		viper.Set(getWorkspaceDefaultConnectionsKey(workspace), slices.DeleteFunc(defaults, func(name string) bool {
+			return name == connectionName
+		}))
+	}
+	if save {
+		err := writeConfig()
+		if err != nil {
+			slog.Error("DeleteWorkspaceConnection Error", "Error", err)
+			return false, errors.New("error removing workspace connection")
+		}
+	}
+	return true, nil
+}
+
+func RenameWorkspaceConnection(workspace string, oldName string, newName string, save bool) (ok bool, err error) {
+	conn, err := WorkspaceConnection(workspace, oldName)
+	if err != nil {
+		return false, err
+	}
+	if _, err := WorkspaceConnection(workspace, newName); err == nil {
+		return false, fmt.Errorf("connection %q already exists in workspace", newName)
+	}
+
+	defaults := WorkspaceDefaultConnections(workspace)
+	if _, err := DeleteWorkspaceConnection(workspace, oldName, false); err != nil {
+		return false, err
+	}
+	if i := slices.Index(defaults, oldName); i >= 0 {
+		defaults[i] = newName
+		viper.Set(getWorkspaceDefaultConnectionsKey(workspace), defaults)
+	}
+	conn.Name = newName
+	return SetWorkspaceConnection(workspace, conn, save)
+}
+
+// WorkspaceDefaultConnections returns the connections that are available to every query
+// in the workspace, attached only when a query references them.
+func WorkspaceDefaultConnections(workspace string) []string {
+	return viper.GetStringSlice(getWorkspaceDefaultConnectionsKey(workspace))
+}
 
-	if workspaceConnections == nil {
-		return nil, errors.New("no connections found in workspace")
+func SetWorkspaceDefaultConnections(workspace string, names []string, save bool) (ok bool, err error) {
+	viper.Set(getWorkspaceDefaultConnectionsKey(workspace), names)
+	if save {
+		err := writeConfig()
+		if err != nil {
+			slog.Error("SetWorkspaceDefaultConnections Error", "Error", err)
+			return false, errors.New("error setting workspace default connections")
+		}
 	}
-	// Map of all keys in the workspaceConnections and return a string array container the 'name' of each connection
+	return true, nil
+}
 
-	return workspaceConnections.AllKeys(), nil
+func SetWorkspaceQuery(workspace string, query savedquery.SavedQuery, save bool) (ok bool, err error) {
+	viper.Set(getWorkspaceQueryKey(workspace, query.Name), query)
+	if save {
+		err := writeConfig()
+		if err != nil {
+			slog.Error("SetWorkspaceQuery Error", "Error", err)
+			return false, errors.New("error saving workspace query")
+		}
+	}
+	return true, nil
+}
+
+func WorkspaceQuery(workspace string, queryName string) (savedquery.SavedQuery, error) {
+	configQuery := viper.Sub(getWorkspaceQueryKey(workspace, queryName))
+	if configQuery == nil {
+		return savedquery.SavedQuery{}, fmt.Errorf("saved query %q not found in workspace", queryName)
+	}
+	return savedquery.SavedQueryFromViper(configQuery), nil
+}
+
+// ListWorkspaceQueries returns the names of the saved queries in a workspace, sorted.
+func ListWorkspaceQueries(workspace string) ([]string, error) {
+	queries := viper.GetStringMap(fmt.Sprintf("%s.queries", workspace))
+
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func DeleteWorkspaceQuery(workspace string, queryName string, save bool) (ok bool, err error) {
+	if _, err := WorkspaceQuery(workspace, queryName); err != nil {
+		return false, err
+	}
+	if err := unsetKey(getWorkspaceQueryKey(workspace, queryName)); err != nil {
+		slog.Error("DeleteWorkspaceQuery Error", "Error", err)
+		return false, errors.New("error removing workspace query")
+	}
+	if save {
+		err := writeConfig()
+		if err != nil {
+			slog.Error("DeleteWorkspaceQuery Error", "Error", err)
+			return false, errors.New("error removing workspace query")
+		}
+	}
+	return true, nil
+}
+
+// DefaultWorkspaceKey holds the workspace used when -w isn't passed, set by dt workspace use.
+const DefaultWorkspaceKey = "default_workspace"
+
+// reservedNames are top level config keys that aren't workspaces.
+var reservedNames = []string{"workspace", DefaultWorkspaceKey, "on_connection_error", "secrets_file", "log_level", "engine", "lock_timeout"}
+
+var workspaceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidateName checks that name can be used as a workspace. It is both a folder name and
+// a config key, and config keys are case insensitive, so only lower case is allowed.
+func ValidateName(name string) error {
+	if !workspaceNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid workspace name %q: use lower case letters, digits, _ and -", name)
+	}
+	if slices.Contains(reservedNames, name) {
+		return fmt.Errorf("invalid workspace name %q: reserved for dt settings", name)
+	}
+	return nil
+}
+
+// ListWorkspaces returns the names of the workspaces with settings in the config, sorted.
+func ListWorkspaces() []string {
+	var names []string
+	for key, value := range viper.AllSettings() {
+		if _, ok := value.(map[string]interface{}); ok && !slices.Contains(reservedNames, key) {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WorkspaceSettings returns a copy of a workspace's settings as they are written to the config file.
+func WorkspaceSettings(workspace string) (map[string]interface{}, error) {
+	settings, ok := viper.AllSettings()[strings.ToLower(workspace)].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("workspace %q not found in config", workspace)
+	}
+
+	// Round trip through yaml for a deep copy, with connections and queries as plain maps
+	encoded, err := yaml.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	copied := map[string]interface{}{}
+	if err := yaml.Unmarshal(encoded, &copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
+
+// CopyWorkspace copies the connections, saved queries and other settings of one
+// workspace to another, replacing what the target had.
+func CopyWorkspace(from string, to string, save bool) (ok bool, err error) {
+	settings, err := WorkspaceSettings(from)
+	if err != nil {
+		return false, err
+	}
+	viper.Set(to, settings)
+	if save {
+		err := writeConfig()
+		if err != nil {
+			slog.Error("CopyWorkspace Error", "Error", err)
+			return false, errors.New("error copying workspace")
+		}
+	}
+	return true, nil
+}
+
+// DeleteWorkspace removes a workspace's settings from the config.
+func DeleteWorkspace(workspace string, save bool) (ok bool, err error) {
+	if err := unsetKey(workspace); err != nil {
+		return false, fmt.Errorf("workspace %q not found in config", workspace)
+	}
+	if viper.GetString(DefaultWorkspaceKey) == workspace {
+		if err := unsetKey(DefaultWorkspaceKey); err != nil {
+			return false, err
+		}
+	}
+	if save {
+		err := writeConfig()
+		if err != nil {
+			slog.Error("DeleteWorkspace Error", "Error", err)
+			return false, errors.New("error removing workspace")
+		}
+	}
+	return true, nil
+}
+
+// RenameWorkspace moves a workspace's settings to a new name, keeping it the default
+// workspace if it was.
+func RenameWorkspace(oldName string, newName string, save bool) (ok bool, err error) {
+	if _, err := WorkspaceSettings(newName); err == nil {
+		return false, fmt.Errorf("workspace %q already exists", newName)
+	}
+	isDefault := viper.GetString(DefaultWorkspaceKey) == oldName
+
+	if _, err := CopyWorkspace(oldName, newName, false); err != nil {
+		return false, err
+	}
+	if _, err := DeleteWorkspace(oldName, false); err != nil {
+		return false, err
+	}
+	if isDefault {
+		viper.Set(DefaultWorkspaceKey, newName)
+	}
+	if save {
+		err := writeConfig()
+		if err != nil {
+			slog.Error("RenameWorkspace Error", "Error", err)
+			return false, errors.New("error renaming workspace")
+		}
+	}
+	return true, nil
+}
+
+func SetDefaultWorkspace(workspace string, save bool) (ok bool, err error) {
+	viper.Set(DefaultWorkspaceKey, workspace)
+	if save {
+		err := writeConfig()
+		if err != nil {
+			slog.Error("SetDefaultWorkspace Error", "Error", err)
+			return false, errors.New("error setting default workspace")
+		}
+	}
+	return true, nil
+}
+
+// WorkspaceBootSQL returns the workspace's boot SQL, run on every connection once its
+// connections are attached. The boot setting is either one SQL string or a list of them.
+func WorkspaceBootSQL(workspace string) []string {
+	switch boot := viper.Get(fmt.Sprintf("%s.boot", workspace)).(type) {
+	case string:
+		return []string{boot}
+	case []string:
+		return boot
+	case []interface{}:
+		statements := make([]string, 0, len(boot))
+		for _, statement := range boot {
+			statements = append(statements, fmt.Sprint(statement))
+		}
+		return statements
+	default:
+		return nil
+	}
 }
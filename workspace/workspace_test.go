@@ -0,0 +1,298 @@
+package workspace_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SandwichLabs/duck-tape/connection"
+	"github.com/SandwichLabs/duck-tape/savedquery"
+	"github.com/SandwichLabs/duck-tape/workspace"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTempConfig points viper at an empty config file for the duration of the test.
+func useTempConfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte{}, 0644))
+
+	viper.Reset()
+	viper.SetConfigFile(path)
+	assert.NoError(t, viper.ReadInConfig())
+	t.Cleanup(viper.Reset)
+	return path
+}
+
+func TestWorkspaceQueries(t *testing.T) {
+	path := useTempConfig(t)
+
+	query := savedquery.New("by_id", "select * from t where id = $id", []string{"pg"})
+	_, err := workspace.SetWorkspaceQuery("test_workspace", query, true)
+	assert.NoError(t, err)
+
+	// Read back from disk, as a new dt invocation would
+	viper.Reset()
+	viper.SetConfigFile(path)
+	assert.NoError(t, viper.ReadInConfig())
+
+	names, err := workspace.ListWorkspaceQueries("test_workspace")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"by_id"}, names)
+
+	saved, err := workspace.WorkspaceQuery("test_workspace", "by_id")
+	assert.NoError(t, err)
+	assert.Equal(t, query, saved)
+
+	_, err = workspace.DeleteWorkspaceQuery("test_workspace", "by_id", true)
+	assert.NoError(t, err)
+
+	_, err = workspace.WorkspaceQuery("test_workspace", "by_id")
+	assert.Error(t, err)
+
+	_, err = workspace.DeleteWorkspaceQuery("test_workspace", "by_id", true)
+	assert.Error(t, err)
+}
+
+func TestWorkspaceConnections(t *testing.T) {
+	path := useTempConfig(t)
+
+	conn := connection.ConnectionConfig{Name: "pg", Type: "POSTGRES", ConnString: "host=localhost"}
+	_, err := workspace.SetWorkspaceConnection("test_workspace", conn, true)
+	assert.NoError(t, err)
+
+	viper.Reset()
+	viper.SetConfigFile(path)
+	assert.NoError(t, viper.ReadInConfig())
+
+	_, err = workspace.RenameWorkspaceConnection("test_workspace", "pg", "warehouse", true)
+	assert.NoError(t, err)
+
+	viper.Reset()
+	viper.SetConfigFile(path)
+	assert.NoError(t, viper.ReadInConfig())
+
+	names, err := workspace.ListWorkspaceConnections("test_workspace")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"warehouse"}, names)
+
+	renamed, err := workspace.WorkspaceConnection("test_workspace", "warehouse")
+	assert.NoError(t, err)
+	assert.Equal(t, "warehouse", renamed.Name)
+	assert.Equal(t, conn.ConnString, renamed.ConnString)
+
+	_, err = workspace.DeleteWorkspaceConnection("test_workspace", "warehouse", true)
+	assert.NoError(t, err)
+
+	names, err = workspace.ListWorkspaceConnections("test_workspace")
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestWorkspaceConnectionRoundTrip(t *testing.T) {
+	path := useTempConfig(t)
+
+	values := map[string]string{"host": "db", "port": "5432", "dbname": "app", "user": "app", "password": "${env:PG_PASSWORD}", "sslmode": "require"}
+	connString, err := connection.BuildConnString("POSTGRES", values)
+	assert.NoError(t, err)
+
+	conn := connection.ConnectionConfig{Name: "pg", Type: "POSTGRES", ConnString: connString, EnableWrite: true}
+	_, err = workspace.SetWorkspaceConnection("test_workspace", conn, true)
+	assert.NoError(t, err)
+
+	viper.Reset()
+	viper.SetConfigFile(path)
+	assert.NoError(t, viper.ReadInConfig())
+
+	// What the edit form is pre-filled with
+	saved, err := workspace.WorkspaceConnection("test_workspace", "pg")
+	assert.NoError(t, err)
+	assert.Equal(t, conn, saved)
+	assert.Equal(t, values, connection.ParseConnString(saved.Type, saved.ConnString))
+}
+
+func TestWorkspaceDefaultConnections(t *testing.T) {
+	path := useTempConfig(t)
+
+	for _, name := range []string{"pg", "lake"} {
+		_, err := workspace.SetWorkspaceConnection("test_workspace", connection.ConnectionConfig{Name: name, Type: "DUCKDB", ConnString: name + ".duckdb"}, false)
+		assert.NoError(t, err)
+	}
+	_, err := workspace.SetWorkspaceDefaultConnections("test_workspace", []string{"pg", "lake"}, true)
+	assert.NoError(t, err)
+
+	viper.Reset()
+	viper.SetConfigFile(path)
+	assert.NoError(t, viper.ReadInConfig())
+	assert.Equal(t, []string{"pg", "lake"}, workspace.WorkspaceDefaultConnections("test_workspace"))
+
+	// Renames and removals carry over to the defaults
+	_, err = workspace.RenameWorkspaceConnection("test_workspace", "pg", "warehouse", true)
+	assert.NoError(t, err)
+	_, err = workspace.DeleteWorkspaceConnection("test_workspace", "lake", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"warehouse"}, workspace.WorkspaceDefaultConnections("test_workspace"))
+}
+
+func TestManageWorkspaces(t *testing.T) {
+	path := useTempConfig(t)
+
+	assert.NoError(t, workspace.ValidateName("staging-2"))
+	assert.Error(t, workspace.ValidateName("Staging"))
+	assert.Error(t, workspace.ValidateName("a.b"))
+	assert.Error(t, workspace.ValidateName(workspace.DefaultWorkspaceKey))
+
+	_, err := workspace.SetWorkspaceConnection("dev", connection.ConnectionConfig{Name: "pg", Type: "POSTGRES", ConnString: "host=db"}, false)
+	assert.NoError(t, err)
+	_, err = workspace.SetWorkspaceDefaultConnections("dev", []string{"pg"}, false)
+	assert.NoError(t, err)
+	_, err = workspace.SetDefaultWorkspace("dev", true)
+	assert.NoError(t, err)
+
+	viper.Reset()
+	viper.SetConfigFile(path)
+	assert.NoError(t, viper.ReadInConfig())
+
+	_, err = workspace.CopyWorkspace("dev", "staging", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dev", "staging"}, workspace.ListWorkspaces())
+
+	// The copy doesn't share state with the original
+	_, err = workspace.DeleteWorkspaceConnection("staging", "pg", true)
+	assert.NoError(t, err)
+	_, err = workspace.WorkspaceConnection("dev", "pg")
+	assert.NoError(t, err)
+
+	_, err = workspace.RenameWorkspace("dev", "main", true)
+	assert.NoError(t, err)
+	_, err = workspace.RenameWorkspace("main", "staging", true)
+	assert.Error(t, err)
+
+	viper.Reset()
+	viper.SetConfigFile(path)
+	assert.NoError(t, viper.ReadInConfig())
+
+	assert.Equal(t, []string{"main", "staging"}, workspace.ListWorkspaces())
+	assert.Equal(t, "main", viper.GetString(workspace.DefaultWorkspaceKey))
+	assert.Equal(t, []string{"pg"}, workspace.WorkspaceDefaultConnections("main"))
+
+	_, err = workspace.DeleteWorkspace("main", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"staging"}, workspace.ListWorkspaces())
+	assert.Empty(t, viper.GetString(workspace.DefaultWorkspaceKey))
+}
+
+func TestDeletedWorkspaceStaysDeleted(t *testing.T) {
+	path := useTempConfig(t)
+	viper.SetDefault("log_level", "warn")
+	t.Setenv("DT_TEST_SETTING", "from env")
+	assert.NoError(t, viper.BindEnv("test_setting", "DT_TEST_SETTING"))
+
+	_, err := workspace.SetWorkspaceDb("staging", "/data/staging.db", true)
+	assert.NoError(t, err)
+	// Set without saving, as EnsureWorkspace does for the default database
+	_, err = workspace.SetWorkspaceDb("old", "/data/old.db", false)
+	assert.NoError(t, err)
+
+	_, err = workspace.DeleteWorkspace("old", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"staging"}, workspace.ListWorkspaces())
+	// Only the key is removed, defaults and environment bindings stay in place
+	assert.Equal(t, "warn", viper.GetString("log_level"))
+	assert.Equal(t, "from env", viper.GetString("test_setting"))
+
+	// An unrelated save doesn't bring it back
+	_, err = workspace.SetWorkspaceDb("staging", "/data/staging2.db", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"staging"}, workspace.ListWorkspaces())
+
+	saved := viper.New()
+	saved.SetConfigFile(path)
+	assert.NoError(t, saved.ReadInConfig())
+	assert.False(t, saved.IsSet("old"))
+	assert.Equal(t, "/data/staging2.db", saved.GetString("staging.dbLocation"))
+}
+
+func TestProject(t *testing.T) {
+	userPath := useTempConfig(t)
+	assert.NoError(t, os.WriteFile(userPath, []byte("on_connection_error: fail\nmy_repo:\n  connections:\n    mine:\n      name: mine\n      type: DUCKDB\n      conn_string: mine.duckdb\n"), 0644))
+	assert.NoError(t, viper.ReadInConfig())
+
+	dir := filepath.Join(t.TempDir(), "My Repo")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sql", "reports"), 0755))
+	projectPath := filepath.Join(dir, "dt.yaml")
+	assert.NoError(t, os.WriteFile(projectPath, []byte("dbLocation: data/dt.db\non_connection_error: skip\nconnections:\n  pg:\n    name: pg\n    type: POSTGRES\n    conn_string: host=db\n"), 0644))
+
+	found, ok := workspace.FindProject(filepath.Join(dir, "sql", "reports"))
+	assert.True(t, ok)
+	assert.Equal(t, projectPath, found)
+
+	project, err := workspace.LoadProject(found)
+	assert.NoError(t, err)
+	assert.Equal(t, "my-repo", project.Workspace)
+	assert.Equal(t, filepath.Join(dir, "data", "dt.db"), project.Settings["dblocation"])
+
+	project.Workspace = "my_repo"
+	assert.NoError(t, workspace.UseProject(project))
+	t.Cleanup(func() { workspace.UseProject(nil) })
+
+	// The project is merged over the user's workspace of the same name
+	names, err := workspace.ListWorkspaceConnections("my_repo")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"mine", "pg"}, names)
+	assert.Equal(t, "skip", viper.GetString("on_connection_error"))
+	assert.Equal(t, "my_repo", viper.GetString("workspace"))
+
+	_, err = workspace.SetWorkspaceConnection("my_repo", connection.ConnectionConfig{Name: "lake", Type: "DUCKDB", ConnString: "lake.duckdb"}, true)
+	assert.NoError(t, err)
+
+	// Changes go to the project file and the user config keeps its own values
+	saved, err := workspace.LoadProject(projectPath)
+	assert.NoError(t, err)
+	assert.Contains(t, saved.Settings["connections"], "lake")
+	assert.Contains(t, saved.Settings["connections"], "pg")
+	assert.NotContains(t, saved.Settings["connections"], "mine")
+	assert.Equal(t, filepath.Join(dir, "data", "dt.db"), saved.Settings["dblocation"])
+
+	user := viper.New()
+	user.SetConfigFile(userPath)
+	assert.NoError(t, user.ReadInConfig())
+	assert.Equal(t, "fail", user.GetString("on_connection_error"))
+	assert.True(t, user.IsSet("my_repo.connections.mine"))
+	assert.False(t, user.IsSet("my_repo.connections.lake"))
+}
+
+func TestProjectTrust(t *testing.T) {
+	useTempConfig(t)
+	dir := t.TempDir()
+	projectPath := filepath.Join(dir, ".dt.yaml")
+	assert.NoError(t, os.WriteFile(projectPath, []byte("workspace: repo\nboot: SELECT 1\n"), 0644))
+	trust := workspace.ProjectTrust{Path: filepath.Join(t.TempDir(), "trusted_projects.yaml")}
+
+	project, err := workspace.LoadProject(projectPath)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, trust.Check(project), workspace.ErrProjectUntrusted)
+
+	assert.NoError(t, trust.Trust(project))
+	assert.NoError(t, trust.Check(project))
+
+	// Saving changes through dt keeps the file trusted
+	project.Trust = &trust
+	assert.NoError(t, workspace.UseProject(project))
+	t.Cleanup(func() { workspace.UseProject(nil) })
+	_, err = workspace.SetWorkspaceDb("repo", filepath.Join(dir, "repo.db"), true)
+	assert.NoError(t, err)
+	saved, err := workspace.LoadProject(projectPath)
+	assert.NoError(t, err)
+	assert.NoError(t, trust.Check(saved))
+
+	// Any other change has to be trusted again
+	assert.NoError(t, os.WriteFile(projectPath, []byte("workspace: repo\nboot: SELECT 2\n"), 0644))
+	edited, err := workspace.LoadProject(projectPath)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, trust.Check(edited), workspace.ErrProjectChanged)
+
+	assert.NoError(t, trust.Untrust(projectPath))
+	assert.ErrorIs(t, trust.Check(edited), workspace.ErrProjectUntrusted)
+	assert.Error(t, trust.Untrust(projectPath))
+}